@@ -0,0 +1,564 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// backupTimeFormat names snapshot directories, sorting them oldest first
+const backupTimeFormat = "20060102T150405Z"
+
+// backupManifestFile is written into each snapshot to describe it
+const backupManifestFile = "manifest.json"
+
+var (
+	backupRoot       string
+	backupKeepDaily  int
+	backupKeepWeekly int
+	backupDiffLines  bool
+)
+
+// BackupManifest describes a snapshot
+type BackupManifest struct {
+	Profile  string    `json:"profile"`
+	Base     string    `json:"base"`
+	Created  time.Time `json:"created"`
+	Complete bool      `json:"complete"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// backupCmd is the top level command for account snapshots
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Snapshot backups of an account",
+	Long: `Take, list and compare timestamped snapshots of an account's
+formulas, resources, transformations, custom elements, jobs, branding and
+formula instance configurations. Snapshots are kept in <root>/<profile>/<timestamp>`,
+}
+
+// backupRunCmd takes a snapshot and applies the retention policy
+var backupRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Take a snapshot of the account",
+	Long: `Takes a full snapshot of the account into the backup root, then removes
+snapshots outside of the retention policy: the newest snapshot of each of the last
+--keep-daily days and of each of the last --keep-weekly weeks are kept. Only
+complete snapshots count towards these; incomplete ones are kept only while
+they're newer than every complete snapshot.
+Setting both to 0 keeps every snapshot. Suitable for running from cron.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		now := time.Now().UTC()
+		profileDir := filepath.Join(backupRoot, profile)
+		dir := filepath.Join(profileDir, now.Format(backupTimeFormat))
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			fmt.Println("Unable to create snapshot directory", err.Error())
+			os.Exit(1)
+		}
+		log.Printf("Taking snapshot of profile %s into %s", profile, dir)
+
+		snapshotErr := takeSnapshot(profilemap["base"], profilemap["auth"], dir)
+
+		manifest := BackupManifest{
+			Profile:  profile,
+			Base:     profilemap["base"],
+			Created:  now,
+			Complete: snapshotErr == nil,
+		}
+		if e, ok := snapshotErr.(ExportErrors); ok {
+			for _, v := range e {
+				manifest.Errors = append(manifest.Errors, v.Error())
+			}
+		} else if snapshotErr != nil {
+			manifest.Errors = append(manifest.Errors, snapshotErr.Error())
+		}
+		manifestbytes, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, backupManifestFile), manifestbytes, 0644)
+		if err != nil {
+			fmt.Println("Unable to write snapshot manifest", err.Error())
+			os.Exit(1)
+		}
+
+		if snapshotErr != nil {
+			// don't prune older snapshots in favor of an incomplete one
+			fmt.Println(snapshotErr.Error())
+			fmt.Printf("Snapshot %s is incomplete\n", filepath.Base(dir))
+			os.Exit(1)
+		}
+		fmt.Printf("Snapshot %s complete\n", filepath.Base(dir))
+
+		removed, err := pruneSnapshots(profileDir, backupKeepDaily, backupKeepWeekly)
+		if err != nil {
+			fmt.Println("Unable to apply retention policy", err.Error())
+			os.Exit(1)
+		}
+		for _, v := range removed {
+			fmt.Printf("Removed snapshot %s\n", v)
+		}
+	},
+}
+
+// takeSnapshot writes every backed up asset type into dir, reusing the molecules
+// export writers in canonical form so snapshots can be compared file by file
+func takeSnapshot(base, auth, dir string) error {
+	exportCanonical = true
+
+	// stages run one after another so --concurrency bounds the whole snapshot
+	var errs []error
+	stages := []func() error{
+		func() error { return ExportAllFormulasToDir(base, auth, filepath.Join(dir, "formulas")) },
+		func() error { return ExportAllResourcesToDir(base, auth, filepath.Join(dir, "resources")) },
+		func() error { return ExportAllTransformationsToDir(base, auth, filepath.Join(dir, "transformations")) },
+		func() error { return ExportCustomElementsToDir(base, auth, filepath.Join(dir, "elements")) },
+		func() error { return ExportFormulaInstancesToDir(base, auth, filepath.Join(dir, "formula-instances")) },
+		func() error { return ExportJobsToFile(base, auth, filepath.Join(dir, "jobs.json")) },
+		func() error { return ExportBrandingToFile(base, auth, filepath.Join(dir, "branding.json")) },
+	}
+	for _, stage := range stages {
+		errs = append(errs, stage())
+	}
+
+	return mergeExportErrors(errs...)
+}
+
+// ExportCustomElementsToDir writes the export JSON of each custom Element to a directory
+func ExportCustomElementsToDir(base, auth string, dirname string) error {
+	bodybytes, status, _, err := ce.GetAllElements(base, auth)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("HTTP Status Code listing Elements: %v", status)
+	}
+	customElementsOnly, err := ce.FilterCustomElements(bodybytes)
+	if err != nil {
+		return err
+	}
+	var elements ce.Elements
+	err = json.Unmarshal(customElementsOnly, &elements)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dirname, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	var errs exportErrorCollector
+	progress := newExportProgress("Custom Elements", len(elements))
+	forEachConcurrently(len(elements), func(i int) {
+		e := elements[i]
+		bodybytes, status, _, err := ce.GetExportElement(base, auth, strconv.Itoa(e.ID))
+		if err != nil {
+			errs.add(fmt.Errorf("Element %s: %s", e.Key, err.Error()))
+			return
+		}
+		if status != 200 {
+			errs.add(fmt.Errorf("Element %s: HTTP Status Code %v", e.Key, status))
+			return
+		}
+		filename := filepath.Join(dirname, fmt.Sprintf("%s.element.json", e.Key))
+		err = writeExportFile(filename, bodybytes, assetElement)
+		if err != nil {
+			errs.add(fmt.Errorf("Element %s: %s", e.Key, err.Error()))
+			return
+		}
+		progress.step(e.Key)
+	})
+
+	return errs.err()
+}
+
+// ExportFormulaInstancesToDir writes the Instances, including configuration,
+// of each Formula that has any to a directory
+func ExportFormulaInstancesToDir(base, auth string, dirname string) error {
+	bodybytes, status, _, err := ce.FormulasList(base, auth)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("HTTP Status Code listing Formulas: %v", status)
+	}
+	var formulas []ce.Formula
+	err = json.Unmarshal(bodybytes, &formulas)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dirname, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	var errs exportErrorCollector
+	progress := newExportProgress("Formula Instances", len(formulas))
+	forEachConcurrently(len(formulas), func(i int) {
+		f := formulas[i]
+		bodybytes, status, _, err := ce.GetFormulaInstances(base, auth, strconv.Itoa(f.ID))
+		if err != nil {
+			errs.add(fmt.Errorf("Formula %s Instances: %s", f.Name, err.Error()))
+			return
+		}
+		if status != 200 {
+			errs.add(fmt.Errorf("Formula %s Instances: HTTP Status Code %v", f.Name, status))
+			return
+		}
+		progress.step(f.Name)
+		var instances []interface{}
+		if json.Unmarshal(bodybytes, &instances) == nil && len(instances) == 0 {
+			return
+		}
+		name := fmt.Sprintf("%s.instances.json", strings.Replace(f.Name, " ", "", -1))
+		err = writeExportFile(filepath.Join(dirname, name), bodybytes, assetInstance)
+		if err != nil {
+			errs.add(fmt.Errorf("Formula %s Instances: %s", f.Name, err.Error()))
+		}
+	})
+
+	return errs.err()
+}
+
+// ExportJobsToFile writes the account's jobs to a file
+func ExportJobsToFile(base, auth string, filename string) error {
+	bodybytes, status, _, err := ce.ListJobs(base, auth)
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return fmt.Errorf("HTTP Status Code listing Jobs: %v", status)
+	}
+	log.Println("Exporting Jobs")
+	return writeExportFile(filename, bodybytes, assetJob)
+}
+
+// ExportBrandingToFile writes the account's branding to a file, if there is any
+func ExportBrandingToFile(base, auth string, filename string) error {
+	bodybytes, status, _, err := ce.GetBranding(base, auth, debug)
+	if err != nil {
+		return err
+	}
+	if status == 404 { // no branding on this account
+		return nil
+	}
+	if status != 200 {
+		return fmt.Errorf("HTTP Status Code retrieving Branding: %v", status)
+	}
+	log.Println("Exporting Branding")
+	return writeExportFile(filename, bodybytes, assetBranding)
+}
+
+// listSnapshots returns the snapshot names in a profile's backup directory, oldest first
+func listSnapshots(profileDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(profileDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var snapshots []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, e.Name()); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, e.Name())
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// snapshotsToKeep applies the retention policy to snapshot names (oldest first),
+// keeping the newest complete snapshot of each of the last keepDaily days and
+// keepWeekly weeks. Incomplete snapshots don't take a day's or week's place, and
+// are kept only if nothing complete has been taken since.
+func snapshotsToKeep(snapshots []string, complete map[string]bool, keepDaily, keepWeekly int) map[string]bool {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	newest := true
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if !complete[snapshots[i]] {
+			if newest {
+				keep[snapshots[i]] = true
+			}
+			continue
+		}
+		newest = false
+		t, err := time.Parse(backupTimeFormat, snapshots[i])
+		if err != nil {
+			continue
+		}
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[snapshots[i]] = true
+		}
+		year, week := t.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep[snapshots[i]] = true
+		}
+	}
+	return keep
+}
+
+// snapshotComplete reports whether a snapshot's manifest says everything was exported
+func snapshotComplete(dir string) bool {
+	var manifest BackupManifest
+	manifestbytes, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil || json.Unmarshal(manifestbytes, &manifest) != nil {
+		return false
+	}
+	return manifest.Complete
+}
+
+// pruneSnapshots removes snapshots outside of the retention policy, returning their names
+func pruneSnapshots(profileDir string, keepDaily, keepWeekly int) ([]string, error) {
+	if keepDaily < 1 && keepWeekly < 1 {
+		return nil, nil
+	}
+	snapshots, err := listSnapshots(profileDir)
+	if err != nil {
+		return nil, err
+	}
+	complete := make(map[string]bool)
+	for _, v := range snapshots {
+		complete[v] = snapshotComplete(filepath.Join(profileDir, v))
+	}
+	keep := snapshotsToKeep(snapshots, complete, keepDaily, keepWeekly)
+	var removed []string
+	for _, v := range snapshots {
+		if keep[v] {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(profileDir, v))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, v)
+	}
+	return removed, nil
+}
+
+// snapshotFiles returns the files of a snapshot relative to its directory
+func snapshotFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == backupManifestFile {
+			return nil
+		}
+		files[rel] = path
+		return nil
+	})
+	return files, err
+}
+
+// resolveSnapshot accepts either a snapshot name for the profile or a path to a snapshot
+func resolveSnapshot(name string) (string, error) {
+	candidates := []string{filepath.Join(backupRoot, profile, name), name}
+	for _, v := range candidates {
+		if info, err := os.Stat(v); err == nil && info.IsDir() {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find snapshot %s in %s", name, filepath.Join(backupRoot, profile))
+}
+
+// backupListCmd lists snapshots for a profile
+var backupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots of the account",
+	Long:  `Lists the snapshots taken of the profile's account, oldest first`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profileDir := filepath.Join(backupRoot, profile)
+		snapshots, err := listSnapshots(profileDir)
+		if err != nil {
+			fmt.Println("Unable to list snapshots", err.Error())
+			os.Exit(1)
+		}
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots in %s\n", profileDir)
+			return
+		}
+
+		data := [][]string{}
+		for _, v := range snapshots {
+			var manifest BackupManifest
+			status := "unknown"
+			manifestbytes, err := ioutil.ReadFile(filepath.Join(profileDir, v, backupManifestFile))
+			if err == nil && json.Unmarshal(manifestbytes, &manifest) == nil {
+				status = "complete"
+				if !manifest.Complete {
+					status = fmt.Sprintf("incomplete (%v errors)", len(manifest.Errors))
+				}
+			}
+			files, _ := snapshotFiles(filepath.Join(profileDir, v))
+			t, _ := time.Parse(backupTimeFormat, v)
+			data = append(data, []string{
+				v,
+				t.Local().Format(time.RFC1123),
+				strconv.Itoa(len(files)),
+				status,
+			})
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Snapshot", "Taken", "Files", "Status"})
+		table.SetBorder(false)
+		table.AppendBulk(data)
+		table.Render()
+	},
+}
+
+// backupDiffCmd compares two snapshots
+var backupDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Show what changed between two snapshots",
+	Long: `Lists the files added, removed and changed from snapshot a to snapshot b.
+Snapshots can be given by name (see backup list) or by path.
+Use --lines to show a line diff of each changed file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("must supply two snapshots to compare")
+			cmd.Help()
+			os.Exit(1)
+		}
+		from, err := resolveSnapshot(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		to, err := resolveSnapshot(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fromFiles, err := snapshotFiles(from)
+		if err != nil {
+			fmt.Println("Unable to read snapshot", args[0], err.Error())
+			os.Exit(1)
+		}
+		toFiles, err := snapshotFiles(to)
+		if err != nil {
+			fmt.Println("Unable to read snapshot", args[1], err.Error())
+			os.Exit(1)
+		}
+
+		var names []string
+		for k := range fromFiles {
+			names = append(names, k)
+		}
+		for k := range toFiles {
+			if _, ok := fromFiles[k]; !ok {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+
+		changes := 0
+		for _, name := range names {
+			fromPath, inFrom := fromFiles[name]
+			toPath, inTo := toFiles[name]
+			switch {
+			case !inFrom:
+				fmt.Printf("added    %s\n", name)
+				changes++
+			case !inTo:
+				fmt.Printf("removed  %s\n", name)
+				changes++
+			default:
+				a, err := ioutil.ReadFile(fromPath)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				b, err := ioutil.ReadFile(toPath)
+				if err != nil {
+					fmt.Println(err.Error())
+					os.Exit(1)
+				}
+				if bytes.Equal(a, b) {
+					continue
+				}
+				fmt.Printf("changed  %s\n", name)
+				changes++
+				if backupDiffLines {
+					fmt.Print(unifiedDiff(
+						filepath.Join(args[0], name), filepath.Join(args[1], name),
+						string(a), string(b), 3))
+				}
+			}
+		}
+		if changes == 0 {
+			fmt.Println("No changes")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+
+	backupCmd.PersistentFlags().StringVar(&profile, "profile", "default", "profile name")
+	backupCmd.PersistentFlags().StringVar(&backupRoot, "root", filepath.Join(os.Getenv("HOME"), ".config", "ce", "backups"), "backup root directory")
+
+	backupCmd.AddCommand(backupRunCmd)
+	backupRunCmd.Flags().IntVar(&backupKeepDaily, "keep-daily", 7, "number of daily snapshots to keep")
+	backupRunCmd.Flags().IntVar(&backupKeepWeekly, "keep-weekly", 4, "number of weekly snapshots to keep")
+	backupRunCmd.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+
+	backupCmd.AddCommand(backupListCmd)
+
+	backupCmd.AddCommand(backupDiffCmd)
+	backupDiffCmd.Flags().BoolVar(&backupDiffLines, "lines", false, "show a line diff of changed files")
+}
@@ -0,0 +1,93 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSnapshotsToKeep(t *testing.T) {
+	tests := []struct {
+		name       string
+		snapshots  []string
+		incomplete []string
+		keepDaily  int
+		keepWeekly int
+		want       []string
+	}{
+		{
+			name:      "newest of each day",
+			snapshots: []string{"20240101T000000Z", "20240102T000000Z", "20240102T010000Z", "20240103T000000Z"},
+			keepDaily: 2,
+			want:      []string{"20240102T010000Z", "20240103T000000Z"},
+		},
+		{
+			name:       "newest of each week",
+			snapshots:  []string{"20240101T000000Z", "20240103T000000Z", "20240108T000000Z", "20240115T000000Z"},
+			keepWeekly: 2,
+			want:       []string{"20240108T000000Z", "20240115T000000Z"},
+		},
+		{
+			name:       "incomplete snapshot newer than every complete one is kept",
+			snapshots:  []string{"20240101T000000Z", "20240102T000000Z"},
+			incomplete: []string{"20240102T000000Z"},
+			keepDaily:  1,
+			want:       []string{"20240101T000000Z", "20240102T000000Z"},
+		},
+		{
+			name:       "incomplete snapshot doesn't take a day",
+			snapshots:  []string{"20240101T000000Z", "20240102T000000Z", "20240103T000000Z"},
+			incomplete: []string{"20240102T000000Z"},
+			keepDaily:  2,
+			want:       []string{"20240101T000000Z", "20240103T000000Z"},
+		},
+		{
+			name:       "incomplete snapshot doesn't take a day's newest",
+			snapshots:  []string{"20240102T000000Z", "20240102T010000Z", "20240103T000000Z"},
+			incomplete: []string{"20240102T010000Z"},
+			keepDaily:  2,
+			want:       []string{"20240102T000000Z", "20240103T000000Z"},
+		},
+		{
+			name:       "incomplete snapshot doesn't take a week",
+			snapshots:  []string{"20240108T000000Z", "20240109T000000Z", "20240115T000000Z"},
+			incomplete: []string{"20240109T000000Z"},
+			keepWeekly: 2,
+			want:       []string{"20240108T000000Z", "20240115T000000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complete := make(map[string]bool)
+			for _, s := range tt.snapshots {
+				complete[s] = true
+			}
+			for _, s := range tt.incomplete {
+				complete[s] = false
+			}
+			var got []string
+			for s := range snapshotsToKeep(tt.snapshots, complete, tt.keepDaily, tt.keepWeekly) {
+				got = append(got, s)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshotsToKeep() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
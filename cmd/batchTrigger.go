@@ -0,0 +1,259 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+var (
+	triggerDataFile string
+	triggerCSVMap   []string
+	triggerRate     float64
+	triggerResults  string
+)
+
+// triggerRecord is one trigger body read from a data file
+type triggerRecord struct {
+	// Line is the line of the file the record starts on
+	Line int
+	Body string
+	// Err is why the record can't be sent
+	Err error
+}
+
+// triggerResult is the outcome of sending one record, as written to the results file
+type triggerResult struct {
+	Line        int    `json:"line"`
+	ExecutionID int    `json:"executionId,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// triggerBatch sends a trigger for each record of triggerDataFile, writing the
+// outcome of each to the results file, and returns the exit status
+func triggerBatch(id string, profilemap map[string]string) int {
+	records, err := readTriggerRecords(triggerDataFile, triggerCSVMap)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	resultsFile := triggerResults
+	if resultsFile == "" {
+		resultsFile = strings.TrimSuffix(triggerDataFile, filepath.Ext(triggerDataFile)) + ".results.ndjson"
+	}
+	out, err := os.Create(resultsFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	defer out.Close()
+
+	var limiter <-chan time.Time
+	if triggerRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / triggerRate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	// each result is written as soon as it's known, so an interrupted run keeps
+	// what it sent; lines are in the order triggers finish, each with its input line
+	progress := newExportProgress("trigger", len(records))
+	enc := json.NewEncoder(out)
+	var mu sync.Mutex
+	failed := 0
+	var writeErr error
+	forEachConcurrently(len(records), func(i int) {
+		r := records[i]
+		result := triggerResult{Line: r.Line}
+		err := r.Err
+		if err == nil {
+			if limiter != nil {
+				<-limiter
+			}
+			result.ExecutionID, err = triggerInstance(id, r.Body, profilemap)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if result.Error != "" {
+			failed++
+		}
+		if err := enc.Encode(result); err != nil && writeErr == nil {
+			writeErr = err
+		}
+		progress.step(fmt.Sprintf("line %v", r.Line))
+	})
+	if writeErr != nil {
+		fmt.Println("unable to write results:", writeErr.Error())
+		return 1
+	}
+
+	fmt.Printf("Triggered %v of %v record(s), %v failed; results in %s\n", len(records)-failed, len(records), failed, resultsFile)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// triggerInstance triggers a Formula Instance with a body, returning the execution ID
+func triggerInstance(id, body string, profilemap map[string]string) (int, error) {
+	bodybytes, status, _, err := ce.TriggerFormulaInstance(profilemap["base"], profilemap["auth"], id, body)
+	if err != nil {
+		return 0, err
+	}
+	if status != 200 {
+		return 0, platformError(status, bodybytes)
+	}
+	var ex []ce.FormulaInstanceCreationResponse
+	err = json.Unmarshal(bodybytes, &ex)
+	if err != nil || len(ex) == 0 {
+		return 0, fmt.Errorf("no execution in trigger response")
+	}
+	return ex[0].ID, nil
+}
+
+// readTriggerRecords reads trigger bodies from a file, CSV if its name ends in
+// .csv and otherwise one JSON document per line
+func readTriggerRecords(filename string, mapping []string) ([]triggerRecord, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		return readCSVTriggerRecords(f, mapping)
+	}
+	if len(mapping) > 0 {
+		return nil, fmt.Errorf("--map is only for CSV files")
+	}
+	return readNDJSONTriggerRecords(f)
+}
+
+// readNDJSONTriggerRecords reads a JSON document from each line, skipping blank lines.
+// A line that isn't JSON becomes a record that can't be sent, so it's reported.
+func readNDJSONTriggerRecords(r io.Reader) ([]triggerRecord, error) {
+	var records []triggerRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		record := triggerRecord{Line: line, Body: text}
+		var v interface{}
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			record.Err = fmt.Errorf("not JSON: %s", err.Error())
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// readCSVTriggerRecords makes a JSON body of each row of a CSV file with a header.
+// Each mapping is column=path, putting the column's value at a dotted path in the
+// body; without mappings each column is a field of the body named by its header.
+func readCSVTriggerRecords(r io.Reader, mapping []string) ([]triggerRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("CSV header: %s", err.Error())
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.TrimSpace(h)] = i
+	}
+
+	type columnPath struct {
+		column int
+		path   []string
+	}
+	var paths []columnPath
+	if len(mapping) == 0 {
+		for i, h := range header {
+			paths = append(paths, columnPath{i, []string{strings.TrimSpace(h)}})
+		}
+	}
+	for _, m := range mapping {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("--map %s must be column=path", m)
+		}
+		i, ok := columns[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("--map %s: no column %s", m, parts[0])
+		}
+		paths = append(paths, columnPath{i, strings.Split(parts[1], ".")})
+	}
+
+	var records []triggerRecord
+	// rows are counted from the header on line 1, so a quoted value over several lines skews them
+	line := 1
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		record := triggerRecord{Line: line}
+		if err != nil {
+			record.Err = err
+			records = append(records, record)
+			continue
+		}
+		body := make(map[string]interface{})
+		for _, p := range paths {
+			if p.column < len(row) {
+				setPath(body, p.path, row[p.column])
+			}
+		}
+		bodybytes, err := json.Marshal(body)
+		if err != nil {
+			record.Err = err
+		}
+		record.Body = string(bodybytes)
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// setPath sets a value at a path in nested objects, creating them as needed
+func setPath(obj map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			obj[key] = child
+		}
+		obj = child
+	}
+	obj[path[len(path)-1]] = value
+}
@@ -0,0 +1,311 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bulkInstances []int
+	bulkFormula   int
+	bulkStatus    string
+	bulkSince     string
+	bulkOlderThan string
+	bulkYes       bool
+	bulkDryRun    bool
+	bulkReport    string
+)
+
+// executionAction is something done to executions one at a time, such as a retry
+type executionAction struct {
+	verb string
+	// status is the status executions are selected by when --status isn't given
+	status string
+	do     func(id string, profilemap map[string]string) error
+}
+
+var retryAction = executionAction{verb: "retry", status: executionFailed, do: retryExecution}
+
+var cancelAction = executionAction{verb: "cancel", status: executionPending, do: cancelExecution}
+
+// executionActionResult is the outcome of an action on one execution
+type executionActionResult struct {
+	ID         int    `json:"id"`
+	InstanceID int    `json:"instanceId,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Result     string `json:"result"`
+	Error      string `json:"error,omitempty"`
+}
+
+// runExecutionAction carries out an action on the executions given by ID, or on
+// those selected by the flags after showing them and asking to go ahead
+func runExecutionAction(cmd *cobra.Command, args []string, action executionAction) {
+	selecting := len(bulkInstances) > 0 || bulkFormula != 0 || bulkStatus != "" || bulkSince != "" || bulkOlderThan != ""
+	if len(args) == 0 && !selecting {
+		fmt.Println("must supply IDs of Formula Instance Executions, or select them with --instance, --formula, --status, --since or --older-than")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	if len(args) > 0 && selecting {
+		fmt.Println("give either execution IDs or selection flags, not both")
+		os.Exit(1)
+	}
+
+	// check for profile
+	profilemap, err := getAuth(profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var results []executionActionResult
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Println("Please provide a number as a Formula Execution ID")
+			os.Exit(1)
+		}
+		results = append(results, executionActionResult{ID: id})
+	}
+
+	// with --json, stdout is kept for the results document
+	messages := os.Stdout
+	if outputJSON {
+		messages = os.Stderr
+	}
+
+	if selecting {
+		results, err = selectExecutions(action, profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if len(results) == 0 {
+			fmt.Fprintf(messages, "No executions to %s\n", action.verb)
+			return
+		}
+		printExecutionActionResults(messages, results, false)
+		if bulkDryRun {
+			fmt.Fprintf(messages, "Would %s %v execution(s)\n", action.verb, len(results))
+			return
+		}
+		if !bulkYes {
+			if !isTerminal(os.Stdin) {
+				fmt.Fprintf(messages, "use --yes to %s executions without a terminal to confirm on\n", action.verb)
+				os.Exit(1)
+			}
+			answer, err := promptLineTo(messages, bufio.NewReader(os.Stdin), fmt.Sprintf("%s %v execution(s)? [y/N]", strings.ToUpper(action.verb[:1])+action.verb[1:], len(results)))
+			if err != nil || (answer != "y" && answer != "yes") {
+				fmt.Fprintln(messages, "Nothing done")
+				return
+			}
+		}
+	}
+
+	failed := 0
+	forEachConcurrently(len(results), func(i int) {
+		r := &results[i]
+		if err := action.do(strconv.Itoa(r.ID), profilemap); err != nil {
+			r.Result = "failed"
+			r.Error = err.Error()
+			return
+		}
+		r.Result = action.verb + " requested"
+	})
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if outputJSON {
+		resultsbytes, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Printf("%s\n", resultsbytes)
+	} else {
+		printExecutionActionResults(os.Stdout, results, true)
+	}
+	if bulkReport != "" {
+		resultsbytes, _ := json.MarshalIndent(results, "", "  ")
+		if err := ioutil.WriteFile(bulkReport, resultsbytes, 0644); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(messages, "%v of %v execution(s) couldn't be %s\n", failed, len(results), pastTense(action.verb))
+		os.Exit(1)
+	}
+}
+
+// selectExecutions lists the executions of the selected Formula Instances that
+// the flags select
+func selectExecutions(action executionAction, profilemap map[string]string) ([]executionActionResult, error) {
+	q := executionQuery{Status: bulkStatus}
+	if q.Status == "" {
+		q.Status = action.status
+	}
+	if !containsString(executionStatuses, q.Status) {
+		return nil, fmt.Errorf("--status must be one of %s", strings.Join(executionStatuses, ", "))
+	}
+	now := time.Now()
+	var err error
+	if bulkSince != "" {
+		q.Since, err = parseTimeFlag(bulkSince, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if bulkOlderThan != "" {
+		q.Until, err = parseTimeFlag(bulkOlderThan, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var instances []ce.FormulaInstance
+	for _, id := range bulkInstances {
+		instances = append(instances, ce.FormulaInstance{ID: id})
+	}
+	switch {
+	case bulkFormula != 0:
+		formulaInstances, err := ce.GetInstancesOfFormula(bulkFormula, profilemap["base"], profilemap["auth"])
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, formulaInstances...)
+	case len(instances) == 0:
+		formulas, err := listFormulas(profilemap["base"], profilemap["auth"])
+		if err != nil {
+			return nil, err
+		}
+		instances, err = accountFormulaInstances(profilemap["base"], profilemap["auth"], formulas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	perInstance := make([][]executionActionResult, len(instances))
+	var errs exportErrorCollector
+	forEachConcurrently(len(instances), func(i int) {
+		executions, err := listExecutions(strconv.Itoa(instances[i].ID), q, profilemap)
+		if err != nil {
+			errs.add(err)
+			return
+		}
+		for _, e := range executions {
+			perInstance[i] = append(perInstance[i], executionActionResult{ID: e.ID, InstanceID: instances[i].ID, Status: e.Status})
+		}
+	})
+	if err := errs.err(); err != nil {
+		// acting on only some of what was asked for would be surprising
+		return nil, err
+	}
+	var results []executionActionResult
+	for _, r := range perInstance {
+		results = append(results, r...)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results, nil
+}
+
+func printExecutionActionResults(w io.Writer, results []executionActionResult, done bool) {
+	data := [][]string{}
+	for _, r := range results {
+		instance := ""
+		if r.InstanceID != 0 {
+			instance = strconv.Itoa(r.InstanceID)
+		}
+		row := []string{strconv.Itoa(r.ID), instance, r.Status}
+		if done {
+			row = append(row, r.Result, r.Error)
+		}
+		data = append(data, row)
+	}
+	header := []string{"ID", "Instance", "Status"}
+	if done {
+		header = append(header, "Result", "Error")
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader(header)
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+// retryExecution asks the platform to run an execution again
+func retryExecution(id string, profilemap map[string]string) error {
+	bodybytes, status, curlcmd, err := platformRequest("PUT", fmt.Sprintf(ce.FormulaRetryExecutionURI, id), nil, profilemap)
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return platformError(status, bodybytes)
+	}
+	return nil
+}
+
+// cancelExecution asks the platform to stop an execution
+func cancelExecution(id string, profilemap map[string]string) error {
+	bodybytes, status, curlcmd, err := ce.CancelFormulaExecution(profilemap["base"], profilemap["auth"], id)
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return platformError(status, bodybytes)
+	}
+	return nil
+}
+
+func pastTense(verb string) string {
+	if strings.HasSuffix(verb, "y") {
+		return strings.TrimSuffix(verb, "y") + "ied"
+	}
+	return verb + "led"
+}
+
+func init() {
+	for _, c := range []*cobra.Command{retryFormulaInstanceExecutionCmd, cancelExecutionCmd} {
+		c.Flags().IntSliceVar(&bulkInstances, "instance", nil, "select executions of these Formula Instances, comma separated")
+		c.Flags().IntVar(&bulkFormula, "formula", 0, "select executions of the Instances of this Formula")
+		c.Flags().StringVarP(&bulkStatus, "status", "s", "", "select executions with this status")
+		c.Flags().StringVar(&bulkSince, "since", "", "select executions created since, as a duration before now (24h, 7d) or a date")
+		c.Flags().StringVar(&bulkOlderThan, "older-than", "", "select executions created before, as a duration before now (2h, 1d) or a date")
+		c.Flags().BoolVarP(&bulkYes, "yes", "y", false, "don't ask before acting on the selected executions")
+		c.Flags().BoolVar(&bulkDryRun, "dry-run", false, "list the selected executions without acting on them")
+		c.Flags().StringVar(&bulkReport, "report", "", "write the result for each execution to this JSON file")
+		c.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+var (
+	copyToProfile   string
+	copySubFormulas bool
+	copyInstances   bool
+)
+
+// copyFormulaCmd copies a Formula template under a new name
+var copyFormulaCmd = &cobra.Command{
+	Use:   "copy <id> <new-name>",
+	Short: "Copy a Formula to another name",
+	Long: `Copies a Formula template to a new name, in the same account or, with
+--to-profile, in another. IDs and dates the platform assigns are removed so the
+copy is imported as a new Formula.
+Sub-Formula steps keep calling the same Formula IDs. With --sub-formulas and
+--to-profile, the sub-Formulas are copied to the other account as well, under
+their own names, reusing a Formula of the same name if there is one, and the
+copy calls them there.
+With --instances, each Instance of the Formula is created on the copy with the
+same name and configuration, inactive, so the copy can be tried without
+handling events twice. With --to-profile, an Instance whose configuration
+doesn't suit the other account, such as one naming an Element Instance that
+isn't there, is reported and not created.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("must supply a Formula ID and a name for the copy")
+			cmd.Help()
+			os.Exit(1)
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Please provide a number as a Formula ID")
+			os.Exit(1)
+		}
+
+		// check for profile
+		from, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		to := from
+		if copyToProfile != "" && copyToProfile != profile {
+			to, err = getAuth(copyToProfile)
+			if err != nil {
+				fmt.Println(copyToProfile, err)
+				os.Exit(1)
+			}
+		}
+
+		c := &formulaCopier{from: from, to: to, copied: make(map[int]int), copying: make(map[int]bool)}
+		newID, err := c.copy(id, args[1])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if outputJSON {
+			fmt.Printf("{\"id\": %v}\n", newID)
+		} else {
+			fmt.Printf("A copy of Formula %v has been created, named %s, ID %v\n", id, args[1], newID)
+		}
+
+		if copyInstances {
+			if err := c.copyInstances(id, newID); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// formulaCopier copies Formulas from one account to another, or within one
+type formulaCopier struct {
+	from, to map[string]string
+	// copied maps the IDs of sub-Formulas copied to their IDs in the other account
+	copied map[int]int
+	// copying holds the Formulas being copied, to catch sub-Formulas that call each other
+	copying map[int]bool
+	// targetNames maps the names of the other account's Formulas to their IDs
+	targetNames map[string]int
+}
+
+func (c *formulaCopier) crossAccount() bool {
+	return c.from["base"] != c.to["base"] || c.from["auth"] != c.to["auth"]
+}
+
+// copy imports a copy of a Formula under a name, returning its ID
+func (c *formulaCopier) copy(id int, name string) (int, error) {
+	if c.copying[id] {
+		return 0, fmt.Errorf("Formula %v calls itself through its sub-Formulas", id)
+	}
+	c.copying[id] = true
+	defer delete(c.copying, id)
+
+	formulabytes, err := pullFormula(strconv.Itoa(id), c.from)
+	if err != nil {
+		return 0, err
+	}
+	f, err := formula.Parse(formulabytes)
+	if err != nil {
+		return 0, fmt.Errorf("unable to understand formula %v, %s", id, err.Error())
+	}
+
+	var doc map[string]interface{}
+	err = decodeJSON(formulabytes, &doc)
+	if err != nil {
+		return 0, err
+	}
+	stripServerFields(doc)
+	doc["name"] = name
+
+	subs := make(map[string]int)
+	for _, u := range formula.Uses(f) {
+		sub, err := strconv.Atoi(u.Value)
+		if u.Kind != formula.UseSubFormula || err != nil {
+			continue
+		}
+		if !c.crossAccount() {
+			continue
+		}
+		if !copySubFormulas {
+			log.Printf("step %s calls Formula %v of the source account", u.Step, sub)
+			continue
+		}
+		newSub, err := c.subFormula(sub)
+		if err != nil {
+			return 0, fmt.Errorf("sub-Formula %v of step %s: %s", sub, u.Step, err.Error())
+		}
+		subs[u.Step] = newSub
+	}
+	setSubFormulaIDs(doc, subs)
+
+	docbytes, err := json.Marshal(doc)
+	if err != nil {
+		return 0, err
+	}
+	var copied ce.Formula
+	err = json.Unmarshal(docbytes, &copied)
+	if err != nil {
+		return 0, err
+	}
+	bodybytes, status, curlcmd, err := ce.ImportFormula(c.to["base"], c.to["auth"], copied)
+	if err != nil {
+		return 0, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return 0, fmt.Errorf("unable to import %s, %s", name, platformError(status, bodybytes).Error())
+	}
+	var imported ce.Formula
+	err = json.Unmarshal(bodybytes, &imported)
+	if err != nil {
+		return 0, fmt.Errorf("unable to understand import response, %s", err.Error())
+	}
+	return imported.ID, nil
+}
+
+// subFormula returns the ID in the other account of a sub-Formula, copying it
+// there unless a Formula of the same name already exists
+func (c *formulaCopier) subFormula(id int) (int, error) {
+	if newID, ok := c.copied[id]; ok {
+		return newID, nil
+	}
+	if c.targetNames == nil {
+		formulas, err := listFormulas(c.to["base"], c.to["auth"])
+		if err != nil {
+			return 0, err
+		}
+		c.targetNames = make(map[string]int)
+		for _, f := range formulas {
+			c.targetNames[f.Name] = f.ID
+		}
+	}
+
+	formulabytes, err := pullFormula(strconv.Itoa(id), c.from)
+	if err != nil {
+		return 0, err
+	}
+	f, err := formula.Parse(formulabytes)
+	if err != nil {
+		return 0, err
+	}
+	if existing, ok := c.targetNames[f.Name]; ok {
+		log.Printf("sub-Formula %v %s: using Formula %v of the same name", id, f.Name, existing)
+		c.copied[id] = existing
+		return existing, nil
+	}
+
+	newID, err := c.copy(id, f.Name)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("sub-Formula %v %s: copied as %v", id, f.Name, newID)
+	c.copied[id] = newID
+	c.targetNames[f.Name] = newID
+	return newID, nil
+}
+
+// copyInstances creates an inactive Instance of the copy for each Instance of the Formula
+func (c *formulaCopier) copyInstances(id, newID int) error {
+	instances, err := ce.GetInstancesOfFormula(id, c.from["base"], c.from["auth"])
+	if err != nil {
+		return err
+	}
+
+	// configurations naming Element Instances only hold in the account they came from
+	var f *formula.Formula
+	var elementInstances map[int]ce.ElementInstance
+	otherAccount := c.to["base"] != c.from["base"] || c.to["auth"] != c.from["auth"]
+	if otherAccount {
+		formulabytes, err := pullFormula(strconv.Itoa(newID), c.to)
+		if err != nil {
+			return err
+		}
+		f, err = formula.Parse(formulabytes)
+		if err != nil {
+			return fmt.Errorf("unable to understand formula response, %s", err.Error())
+		}
+		elementInstances, err = accountElementInstances(c.to["base"], c.to["auth"])
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, i := range instances {
+		values := instanceConfiguration(i)
+		if otherAccount {
+			if problems := checkInstanceConfiguration(f, values, elementInstances); len(problems) > 0 {
+				fmt.Printf("Instance %v %s not copied, its configuration has %v problem(s) in the other account:\n", i.ID, i.Name, len(problems))
+				for _, p := range problems {
+					fmt.Printf("  %s\n", p)
+				}
+				failed++
+				continue
+			}
+		}
+		config := ce.FormulaInstanceConfig{Name: i.Name, Active: false, Configuration: values}
+		created, err := createFormulaInstance(strconv.Itoa(newID), config, c.to)
+		if err != nil {
+			fmt.Printf("Instance %v %s: %s\n", i.ID, i.Name, err.Error())
+			failed++
+			continue
+		}
+		fmt.Printf("Instance %v %s copied as %v (inactive)\n", i.ID, i.Name, created)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v Instance(s) couldn't be copied", failed)
+	}
+	return nil
+}
+
+// stripServerFields removes the IDs and dates the platform assigns to a Formula,
+// its triggers, steps and configuration, leaving step properties as they are
+func stripServerFields(doc map[string]interface{}) {
+	for _, k := range []string{"id", "createdDate", "updatedDate", "userId", "accountId"} {
+		delete(doc, k)
+	}
+	for _, list := range []string{"triggers", "steps", "configuration"} {
+		entries, _ := doc[list].([]interface{})
+		for _, e := range entries {
+			if entry, ok := e.(map[string]interface{}); ok {
+				delete(entry, "id")
+			}
+		}
+	}
+}
+
+// setSubFormulaIDs points sub-Formula steps, by name, at other Formula IDs,
+// keeping each formulaId as a string or number as it was
+func setSubFormulaIDs(doc map[string]interface{}, ids map[string]int) {
+	if len(ids) == 0 {
+		return
+	}
+	steps, _ := doc["steps"].([]interface{})
+	for _, s := range steps {
+		step, _ := s.(map[string]interface{})
+		name, _ := step["name"].(string)
+		newID, ok := ids[name]
+		if !ok {
+			continue
+		}
+		props, _ := step["properties"].(map[string]interface{})
+		if props == nil {
+			continue
+		}
+		if _, isString := props["formulaId"].(string); isString {
+			props["formulaId"] = strconv.Itoa(newID)
+		} else {
+			props["formulaId"] = newID
+		}
+	}
+}
+
+func init() {
+	formulasCmd.AddCommand(copyFormulaCmd)
+	copyFormulaCmd.Flags().StringVar(&copyToProfile, "to-profile", "", "profile of the account to copy to (default the --profile account)")
+	copyFormulaCmd.Flags().BoolVar(&copySubFormulas, "sub-formulas", false, "copy the sub-Formulas called to the --to-profile account")
+	copyFormulaCmd.Flags().BoolVar(&copyInstances, "instances", false, "create the Formula's Instances on the copy, inactive")
+}
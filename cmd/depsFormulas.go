@@ -0,0 +1,426 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var depsReverse string
+
+// depsFormulasCmd reports what Formulas depend on
+var depsFormulasCmd = &cobra.Command{
+	Use:   "deps [id]",
+	Short: "Show the Elements, Resources and Formulas each Formula depends on",
+	Long: `Analyzes the steps and configuration of Formulas for the Element Instances
+they use, and the Elements of those instances, as configured by each Formula
+Instance, the common Resources their API calls refer to, and the sub-Formulas
+they call. Given a Formula ID, its dependencies are shown as a tree; otherwise
+every Formula is summarized.
+--reverse <element|resource> lists every Formula that depends on an Element key
+or common Resource name, which would break if it were removed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		acct, err := loadDepsAccount(profilemap["base"], profilemap["auth"])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		formulas := acct.formulas
+		if len(args) > 0 {
+			id, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Println("must supply a numeric ID of a Formula")
+				os.Exit(1)
+			}
+			formulas = nil
+			for _, f := range acct.formulas {
+				if f.ID == id {
+					formulas = append(formulas, f)
+				}
+			}
+			if len(formulas) == 0 {
+				fmt.Printf("no Formula with ID %v\n", id)
+				os.Exit(1)
+			}
+		}
+
+		deps, err := analyzeFormulas(profilemap["base"], profilemap["auth"], formulas, acct)
+		if err != nil {
+			// report what could be analyzed, and say what couldn't
+			log.Println(err.Error())
+		}
+
+		if depsReverse != "" {
+			var dependents []formulaDeps
+			for _, d := range deps {
+				if len(d.uses(depsReverse)) > 0 {
+					dependents = append(dependents, d)
+				}
+			}
+			if outputJSON {
+				depsbytes, _ := json.MarshalIndent(dependents, "", "  ")
+				fmt.Printf("%s\n", depsbytes)
+				return
+			}
+			data := [][]string{}
+			for _, d := range dependents {
+				data = append(data, []string{strconv.Itoa(d.ID), d.Name, strconv.FormatBool(d.Active), strings.Join(d.uses(depsReverse), "\n")})
+			}
+			fmt.Printf("%v Formulas depend on %s\n", len(dependents), depsReverse)
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"ID", "Name", "active", "uses"})
+			table.SetBorder(false)
+			table.SetAutoWrapText(false)
+			table.AppendBulk(data)
+			table.Render()
+			return
+		}
+
+		if outputJSON {
+			depsbytes, _ := json.MarshalIndent(deps, "", "  ")
+			fmt.Printf("%s\n", depsbytes)
+			return
+		}
+		if len(args) > 0 {
+			for _, d := range deps {
+				d.printTree()
+			}
+			return
+		}
+		data := [][]string{}
+		for _, d := range deps {
+			var subs []string
+			for _, s := range d.SubFormulas {
+				subs = append(subs, s.Name)
+			}
+			data = append(data, []string{
+				strconv.Itoa(d.ID),
+				d.Name,
+				strings.Join(d.Elements, ", "),
+				strings.Join(d.resourceNames(), ", "),
+				strings.Join(unique(subs), ", "),
+				strconv.Itoa(len(d.Instances)),
+			})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Name", "elements", "resources", "sub-formulas", "element instances"})
+		table.SetBorder(false)
+		table.AppendBulk(data)
+		table.Render()
+	},
+}
+
+// formulaDeps are the dependencies of a Formula
+type formulaDeps struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Active      bool          `json:"active"`
+	Elements    []string      `json:"elements"`
+	Instances   []depInstance `json:"elementInstances"`
+	Resources   []depUse      `json:"resources"`
+	SubFormulas []depUse      `json:"subFormulas"`
+}
+
+// depInstance is an Element Instance a Formula uses
+type depInstance struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Element string `json:"element"`
+	// ConfigKey and FormulaInstance say how the Element Instance was configured,
+	// they're empty when a step names it directly
+	ConfigKey       string   `json:"configKey,omitempty"`
+	FormulaInstance string   `json:"formulaInstance,omitempty"`
+	Steps           []string `json:"steps"`
+}
+
+// depUse is a Resource or sub-Formula used by a step
+type depUse struct {
+	Name   string `json:"name"`
+	Step   string `json:"step"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// uses describes how a Formula depends on an Element key or Resource name
+func (d formulaDeps) uses(name string) []string {
+	var uses []string
+	for _, i := range d.Instances {
+		if i.Element == name {
+			how := fmt.Sprintf("element instance %v %s", i.ID, i.Name)
+			if i.FormulaInstance != "" {
+				how += fmt.Sprintf(" (config %s of %s)", i.ConfigKey, i.FormulaInstance)
+			}
+			uses = append(uses, how)
+		}
+	}
+	for _, r := range d.Resources {
+		if r.Name == name {
+			uses = append(uses, fmt.Sprintf("resource in step %s: %s", r.Step, r.Detail))
+		}
+	}
+	return unique(uses)
+}
+
+func (d formulaDeps) resourceNames() []string {
+	var names []string
+	for _, r := range d.Resources {
+		names = append(names, r.Name)
+	}
+	return unique(names)
+}
+
+func (d formulaDeps) printTree() {
+	fmt.Printf("%v %s\n", d.ID, d.Name)
+	fmt.Println("  elements")
+	for _, e := range d.Elements {
+		fmt.Printf("    %s\n", e)
+	}
+	fmt.Println("  element instances")
+	for _, i := range d.Instances {
+		how := "named in steps"
+		if i.FormulaInstance != "" {
+			how = fmt.Sprintf("config %s of formula instance %s", i.ConfigKey, i.FormulaInstance)
+		}
+		fmt.Printf("    %v %s (%s), %s, used by %s\n", i.ID, i.Name, i.Element, how, stepList(i.Steps))
+	}
+	fmt.Println("  resources")
+	for _, r := range d.Resources {
+		fmt.Printf("    %s, step %s: %s\n", r.Name, r.Step, r.Detail)
+	}
+	fmt.Println("  sub-formulas")
+	for _, s := range d.SubFormulas {
+		fmt.Printf("    %s, step %s\n", s.Name, s.Step)
+	}
+}
+
+func stepList(steps []string) string {
+	if len(steps) == 0 {
+		return "no steps"
+	}
+	return strings.Join(steps, ", ")
+}
+
+// depsAccount is what's needed from an account to resolve Formula dependencies
+type depsAccount struct {
+	formulas  []*formula.Formula
+	names     map[int]string
+	instances map[int]ce.ElementInstance
+	resources map[string]bool
+}
+
+func loadDepsAccount(base, auth string) (*depsAccount, error) {
+	acct := &depsAccount{
+		names:     make(map[int]string),
+		instances: make(map[int]ce.ElementInstance),
+		resources: make(map[string]bool),
+	}
+
+	bodybytes, status, curlcmd, err := ce.FormulasList(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("unable to list Formulas, HTTP %v", status)
+	}
+	var raw []json.RawMessage
+	err = json.Unmarshal(bodybytes, &raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range raw {
+		f, err := formula.Parse(r)
+		if err != nil {
+			return nil, err
+		}
+		acct.formulas = append(acct.formulas, f)
+		acct.names[f.ID] = f.Name
+	}
+
+	bodybytes, status, curlcmd, err = ce.GetAllInstances(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("unable to list Element Instances, HTTP %v", status)
+	}
+	var instances []ce.ElementInstance
+	err = json.Unmarshal(bodybytes, &instances)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range instances {
+		acct.instances[i.ID] = i
+	}
+
+	bodybytes, status, curlcmd, err = ce.ResourcesList(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("unable to list Resources, HTTP %v", status)
+	}
+	var resources []ce.CommonResource
+	err = json.Unmarshal(bodybytes, &resources)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resources {
+		acct.resources[r.Name] = true
+	}
+	return acct, nil
+}
+
+// analyzeFormulas works out the dependencies of each Formula, retrieving their
+// Formula Instances concurrently
+func analyzeFormulas(base, auth string, formulas []*formula.Formula, acct *depsAccount) ([]formulaDeps, error) {
+	deps := make([]formulaDeps, len(formulas))
+	var errs exportErrorCollector
+	forEachConcurrently(len(formulas), func(i int) {
+		f := formulas[i]
+		instances, err := ce.GetInstancesOfFormula(f.ID, base, auth)
+		if err != nil {
+			errs.add(fmt.Errorf("Formula %v instances: %s", f.ID, err.Error()))
+		}
+		deps[i] = analyzeFormula(f, instances, acct)
+	})
+	sort.Slice(deps, func(i, j int) bool { return deps[i].ID < deps[j].ID })
+	return deps, errs.err()
+}
+
+// analyzeFormula resolves the uses of a Formula against the account
+func analyzeFormula(f *formula.Formula, instances []ce.FormulaInstance, acct *depsAccount) formulaDeps {
+	d := formulaDeps{ID: f.ID, Name: f.Name, Active: f.Active}
+
+	keySteps := make(map[string][]string)
+	literalSteps := make(map[int][]string)
+	for _, key := range f.ElementInstanceConfigKeys() {
+		keySteps[key] = nil
+	}
+	for _, u := range formula.Uses(f) {
+		switch u.Kind {
+		case formula.UseElementInstance:
+			if key, ok := formula.ConfigKeyOf(u.Value); ok {
+				keySteps[key] = append(keySteps[key], u.Step)
+			} else if id, ok := formula.ElementInstanceID(u.Value); ok {
+				literalSteps[id] = append(literalSteps[id], u.Step)
+			}
+		case formula.UseAPI:
+			for _, segment := range formula.PathSegments(u.Value) {
+				if acct.resources[segment] {
+					d.Resources = append(d.Resources, depUse{Name: segment, Step: u.Step, Detail: strings.TrimSpace(u.Method + " " + u.Value)})
+				}
+			}
+		case formula.UseSubFormula:
+			name := u.Value
+			if id, err := strconv.Atoi(u.Value); err == nil {
+				name = fmt.Sprintf("%v %s", id, acct.names[id])
+			}
+			d.SubFormulas = append(d.SubFormulas, depUse{Name: name, Step: u.Step})
+		}
+	}
+
+	var keys []string
+	for key := range keySteps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, fi := range instances {
+		config, _ := fi.Configuration.(map[string]interface{})
+		for _, key := range keys {
+			id, ok := formula.ElementInstanceID(config[key])
+			if !ok {
+				continue
+			}
+			i := acct.instance(id)
+			i.ConfigKey = key
+			i.FormulaInstance = fmt.Sprintf("%v %s", fi.ID, fi.Name)
+			i.Steps = unique(keySteps[key])
+			d.Instances = append(d.Instances, i)
+		}
+	}
+	var ids []int
+	for id := range literalSteps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		i := acct.instance(id)
+		i.Steps = unique(literalSteps[id])
+		d.Instances = append(d.Instances, i)
+	}
+
+	var elements []string
+	for _, i := range d.Instances {
+		if i.Element != "" {
+			elements = append(elements, i.Element)
+		}
+	}
+	d.Elements = unique(elements)
+	return d
+}
+
+// instance describes an Element Instance of the account
+func (acct *depsAccount) instance(id int) depInstance {
+	i, ok := acct.instances[id]
+	if !ok {
+		return depInstance{ID: id, Name: "(not found)"}
+	}
+	return depInstance{ID: id, Name: i.Name, Element: i.Element.Key}
+}
+
+// unique returns the distinct values, sorted
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	formulasCmd.AddCommand(depsFormulasCmd)
+	depsFormulasCmd.Flags().StringVar(&depsReverse, "reverse", "", "list the Formulas that depend on an Element key or Resource name")
+}
@@ -0,0 +1,143 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the table used to line up two texts;
+// larger changes are shown as a removal of the old lines and an addition of the new ones
+const maxDiffCells = 4000000
+
+// diffLines returns a line diff of a and b, each line prefixed with
+// "-" (only in a), "+" (only in b) or " " (in both)
+func diffLines(a, b []string) []string {
+	// common prefix and suffix don't need lining up
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var out []string
+	for _, l := range a[:prefix] {
+		out = append(out, " "+l)
+	}
+	out = append(out, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		out = append(out, " "+l)
+	}
+	return out
+}
+
+// diffMiddle lines up a and b by their longest common subsequence
+func diffMiddle(a, b []string) []string {
+	var out []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			out = append(out, "-"+l)
+		}
+		for _, l := range b {
+			out = append(out, "+"+l)
+		}
+		return out
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
+
+// unifiedDiff renders a diff of two texts in unified format, with the given
+// number of context lines around each change. It returns "" when the texts are equal.
+func unifiedDiff(fromName, toName, from, to string, context int) string {
+	if from == to {
+		return ""
+	}
+	lines := diffLines(strings.Split(from, "\n"), strings.Split(to, "\n"))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+	lastPrinted := -1
+	for i, l := range lines {
+		if l[0] == ' ' {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if lastPrinted >= 0 && start <= lastPrinted+1 {
+			start = lastPrinted + 1
+		} else {
+			b.WriteString("@@\n")
+		}
+		end := i + context
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		for k := start; k <= end; k++ {
+			// stop the context early if another change follows, it will print itself
+			if k > i && lines[k][0] != ' ' {
+				break
+			}
+			b.WriteString(lines[k])
+			b.WriteString("\n")
+			lastPrinted = k
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, []string{" a", " b"}},
+		{"changed", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []string{" a", "-b", "+x", " c"}},
+		{"added at the end", []string{"a", "b"}, []string{"a", "b", "c"}, []string{" a", " b", "+c"}},
+		{"removed at the start", []string{"a", "b"}, []string{"b"}, []string{"-a", " b"}},
+		{"from nothing", nil, []string{"x"}, []string{"+x"}},
+		{"lined up", []string{"a", "b", "c", "d"}, []string{"b", "d", "e"}, []string{"-a", " b", "-c", " d", "+e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(l ...string) string { return strings.Join(l, "\n") }
+	tests := []struct {
+		name     string
+		from, to string
+		context  int
+		want     string
+	}{
+		{
+			name: "equal",
+			from: lines("a", "b"),
+			to:   lines("a", "b"),
+		},
+		{
+			name:    "one change with context",
+			from:    lines("a", "b", "c", "d", "e", "f", "g"),
+			to:      lines("a", "b", "c", "X", "e", "f", "g"),
+			context: 1,
+			want:    "--- from\n+++ to\n@@\n c\n-d\n+X\n e\n",
+		},
+		{
+			name:    "distant changes in separate hunks",
+			from:    lines("1", "2", "3", "4", "5", "6", "7", "8", "9"),
+			to:      lines("1", "b", "3", "4", "5", "6", "7", "h", "9"),
+			context: 1,
+			want:    "--- from\n+++ to\n@@\n 1\n-2\n+b\n 3\n@@\n 7\n-8\n+h\n 9\n",
+		},
+		{
+			name:    "nearby changes in one hunk",
+			from:    lines("1", "2", "3", "4", "5"),
+			to:      lines("1", "b", "3", "d", "5"),
+			context: 1,
+			want:    "--- from\n+++ to\n@@\n 1\n-2\n+b\n 3\n-4\n+d\n 5\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("from", "to", tt.from, tt.to, tt.context); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsFormula int
+	statsSince   string
+	statsUntil   string
+	statsSteps   bool
+	statsTop     int
+)
+
+// executionStats summarizes the executions of a Formula Instance, or of all the
+// Instances of a Formula when InstanceID is 0
+type executionStats struct {
+	FormulaID   int            `json:"formulaId"`
+	Formula     string         `json:"formula"`
+	InstanceID  int            `json:"instanceId,omitempty"`
+	Instance    string         `json:"instance,omitempty"`
+	Total       int            `json:"total"`
+	Statuses    map[string]int `json:"statuses"`
+	SuccessRate float64        `json:"successRate"`
+	// P50, P95 and P99 are durations of finished executions, in seconds
+	P50          float64     `json:"p50"`
+	P95          float64     `json:"p95"`
+	P99          float64     `json:"p99"`
+	SlowestSteps []stepStats `json:"slowestSteps,omitempty"`
+	Errors       []errorRank `json:"errors,omitempty"`
+
+	durations []time.Duration
+	steps     map[string][]time.Duration
+	errors    map[string]int
+}
+
+// stepStats summarizes the runs of a step, with durations in seconds
+type stepStats struct {
+	Step string  `json:"step"`
+	Runs int     `json:"runs"`
+	P95  float64 `json:"p95"`
+	Max  float64 `json:"max"`
+}
+
+// errorRank is an error message and how often it occurred
+type errorRank struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+// executionStatsCmd reports execution statistics per Formula and Instance
+var executionStatsCmd = &cobra.Command{
+	Use:   "stats [instanceID...]",
+	Short: "execution statistics per Formula and Instance",
+	Long: `Reports, for each Formula and each of its Instances, the executions created
+between --since (default 30d) and --until: counts by status, the success rate of
+finished executions, p50/p95/p99 durations and the most frequent errors of failed
+steps. --steps also reports the slowest steps, which means fetching the details
+of every execution rather than only those that failed.
+Report on Instances by ID, the Instances of a Formula with --formula, or every
+Instance. Output is a table, or JSON with --json or CSV with --csv.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if statsTop < 0 {
+			fmt.Println("--top can't be negative")
+			os.Exit(1)
+		}
+
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		q, err := executionQueryFlags(statsSince, statsUntil)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		instances, err := statsTargets(args, profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		stats := make([]*executionStats, len(instances))
+		details := make([][]int, len(instances))
+		var errs exportErrorCollector
+		forEachConcurrently(len(instances), func(i int) {
+			instance := instances[i]
+			executions, err := listExecutions(strconv.Itoa(instance.ID), q, profilemap)
+			if err != nil {
+				errs.add(err)
+			}
+			stats[i], details[i] = instanceStats(instance, executions)
+		})
+		addExecutionDetails(stats, details, profilemap, &errs)
+		for _, s := range stats {
+			s.summarize()
+		}
+
+		rows := formulaStats(stats)
+		switch {
+		case outputJSON:
+			statsbytes, _ := json.MarshalIndent(rows, "", "  ")
+			fmt.Printf("%s\n", statsbytes)
+		case outputCSV:
+			writeStatsCSV(rows)
+		default:
+			printStats(rows)
+		}
+
+		if err := errs.err(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// statsTargets returns the Formula Instances to report on, with their Formulas
+func statsTargets(args []string, profilemap map[string]string) ([]ce.FormulaInstance, error) {
+	formulas, err := listFormulas(profilemap["base"], profilemap["auth"])
+	if err != nil {
+		return nil, err
+	}
+	if statsFormula != 0 {
+		formulas, err = selectFormulas(formulas, strconv.Itoa(statsFormula))
+		if err != nil {
+			return nil, err
+		}
+	}
+	all, err := accountFormulaInstances(profilemap["base"], profilemap["auth"], formulas)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return all, nil
+	}
+	byID := make(map[int]ce.FormulaInstance)
+	for _, i := range all {
+		byID[i.ID] = i
+	}
+	var instances []ce.FormulaInstance
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a Formula Instance ID", arg)
+		}
+		instance, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("no Formula Instance %v", id)
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// instanceStats counts the executions of an Instance, returning the IDs of those
+// whose details are needed: the failed ones for their errors, and all of them
+// with --steps
+func instanceStats(instance ce.FormulaInstance, executions []ce.FormulaInstanceExecution) (*executionStats, []int) {
+	s := newExecutionStats(instance.Formula.ID, instance.Formula.Name)
+	s.InstanceID = instance.ID
+	s.Instance = instance.Name
+
+	var details []int
+	for _, e := range executions {
+		s.Total++
+		s.Statuses[e.Status]++
+		if executionFinished(e.Status) && !e.UpdatedDate.Before(e.CreateDate) {
+			s.durations = append(s.durations, e.UpdatedDate.Sub(e.CreateDate))
+		}
+		if statsSteps || e.Status == executionFailed {
+			details = append(details, e.ID)
+		}
+	}
+	return s, details
+}
+
+// addExecutionDetails fetches the details of the executions listed for each
+// Instance, all at once so concurrency applies across Instances, and counts
+// their steps and errors in the Instance's stats
+func addExecutionDetails(stats []*executionStats, details [][]int, profilemap map[string]string, errs *exportErrorCollector) {
+	type detail struct {
+		stats *executionStats
+		id    int
+	}
+	var all []detail
+	for i, ids := range details {
+		for _, id := range ids {
+			all = append(all, detail{stats[i], id})
+		}
+	}
+
+	var mu sync.Mutex
+	forEachConcurrently(len(all), func(i int) {
+		ex, _, err := fetchExecution(strconv.Itoa(all[i].id), profilemap["base"], profilemap["auth"])
+		if err != nil {
+			errs.add(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		s := all[i].stats
+		for _, step := range ex.StepExecutions {
+			if step.StepName == triggerStep {
+				continue
+			}
+			if statsSteps && executionFinished(step.Status) && !step.UpdatedDate.Before(step.CreatedDate) {
+				s.steps[step.StepName] = append(s.steps[step.StepName], step.UpdatedDate.Sub(step.CreatedDate))
+			}
+			if step.Status == executionFailed {
+				s.errors[step.StepName+": "+errorSummary(step.stepError())]++
+			}
+		}
+	})
+}
+
+func newExecutionStats(formulaID int, formulaName string) *executionStats {
+	return &executionStats{
+		FormulaID: formulaID,
+		Formula:   formulaName,
+		Statuses:  make(map[string]int),
+		steps:     make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+	}
+}
+
+// add counts another Instance's executions in a Formula's totals
+func (s *executionStats) add(o *executionStats) {
+	s.Total += o.Total
+	for k, v := range o.Statuses {
+		s.Statuses[k] += v
+	}
+	s.durations = append(s.durations, o.durations...)
+	for k, v := range o.steps {
+		s.steps[k] = append(s.steps[k], v...)
+	}
+	for k, v := range o.errors {
+		s.errors[k] += v
+	}
+}
+
+// summarize works out the rates, percentiles and rankings from what's been counted
+func (s *executionStats) summarize() {
+	finished := 0
+	for status, n := range s.Statuses {
+		if executionFinished(status) {
+			finished += n
+		}
+	}
+	if finished > 0 {
+		s.SuccessRate = float64(s.Statuses[executionSuccess]) / float64(finished)
+	}
+	s.P50 = percentile(s.durations, 50).Seconds()
+	s.P95 = percentile(s.durations, 95).Seconds()
+	s.P99 = percentile(s.durations, 99).Seconds()
+
+	s.SlowestSteps = nil
+	for name, durations := range s.steps {
+		s.SlowestSteps = append(s.SlowestSteps, stepStats{
+			Step: name,
+			Runs: len(durations),
+			P95:  percentile(durations, 95).Seconds(),
+			Max:  percentile(durations, 100).Seconds(),
+		})
+	}
+	sort.Slice(s.SlowestSteps, func(i, j int) bool {
+		if s.SlowestSteps[i].P95 != s.SlowestSteps[j].P95 {
+			return s.SlowestSteps[i].P95 > s.SlowestSteps[j].P95
+		}
+		return s.SlowestSteps[i].Step < s.SlowestSteps[j].Step
+	})
+	if len(s.SlowestSteps) > statsTop {
+		s.SlowestSteps = s.SlowestSteps[:statsTop]
+	}
+
+	s.Errors = nil
+	for message, count := range s.errors {
+		s.Errors = append(s.Errors, errorRank{Error: message, Count: count})
+	}
+	sort.Slice(s.Errors, func(i, j int) bool {
+		if s.Errors[i].Count != s.Errors[j].Count {
+			return s.Errors[i].Count > s.Errors[j].Count
+		}
+		return s.Errors[i].Error < s.Errors[j].Error
+	})
+	if len(s.Errors) > statsTop {
+		s.Errors = s.Errors[:statsTop]
+	}
+}
+
+// formulaStats returns, for each Formula, its totals followed by its Instances
+func formulaStats(instances []*executionStats) []*executionStats {
+	byFormula := make(map[int][]*executionStats)
+	var ids []int
+	for _, s := range instances {
+		if _, ok := byFormula[s.FormulaID]; !ok {
+			ids = append(ids, s.FormulaID)
+		}
+		byFormula[s.FormulaID] = append(byFormula[s.FormulaID], s)
+	}
+	sort.Ints(ids)
+
+	var rows []*executionStats
+	for _, id := range ids {
+		list := byFormula[id]
+		sort.Slice(list, func(i, j int) bool { return list[i].InstanceID < list[j].InstanceID })
+		total := newExecutionStats(id, list[0].Formula)
+		for _, s := range list {
+			total.add(s)
+		}
+		total.summarize()
+		rows = append(rows, total)
+		rows = append(rows, list...)
+	}
+	return rows
+}
+
+// percentile returns the nearest-rank percentile of durations
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// errorSummary shortens an error to its first line, so the same error counts together
+func errorSummary(message string) string {
+	message = strings.TrimSpace(message)
+	if i := strings.Index(message, "\n"); i >= 0 {
+		message = message[:i]
+	}
+	if len(message) > 120 {
+		message = message[:117] + "..."
+	}
+	return message
+}
+
+// statsColumns are the columns of a stats row in the table and CSV
+var statsColumns = []string{"Formula", "Instance", "Total", "success", "failed", "pending", "cancelled", "Success %", "p50 s", "p95 s", "p99 s"}
+
+func (s *executionStats) columns() []string {
+	instance := "(all)"
+	if s.InstanceID != 0 {
+		instance = fmt.Sprintf("%v %s", s.InstanceID, s.Instance)
+	}
+	return []string{
+		fmt.Sprintf("%v %s", s.FormulaID, s.Formula),
+		instance,
+		strconv.Itoa(s.Total),
+		strconv.Itoa(s.Statuses[executionSuccess]),
+		strconv.Itoa(s.Statuses[executionFailed]),
+		strconv.Itoa(s.Statuses[executionPending]),
+		strconv.Itoa(s.Statuses["cancelled"]),
+		strconv.FormatFloat(s.SuccessRate*100, 'f', 1, 64),
+		strconv.FormatFloat(s.P50, 'f', 2, 64),
+		strconv.FormatFloat(s.P95, 'f', 2, 64),
+		strconv.FormatFloat(s.P99, 'f', 2, 64),
+	}
+}
+
+func printStats(rows []*executionStats) {
+	data := [][]string{}
+	for _, s := range rows {
+		data = append(data, s.columns())
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(statsColumns)
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+
+	// slowest steps and errors are shown for each Formula as a whole
+	for _, s := range rows {
+		if s.InstanceID != 0 || (len(s.SlowestSteps) == 0 && len(s.Errors) == 0) {
+			continue
+		}
+		fmt.Printf("\nFormula %v %s\n", s.FormulaID, s.Formula)
+		if len(s.SlowestSteps) > 0 {
+			fmt.Println("Slowest steps:")
+			for _, step := range s.SlowestSteps {
+				fmt.Printf("  %-30s p95 %.2fs  max %.2fs  (%v runs)\n", step.Step, step.P95, step.Max, step.Runs)
+			}
+		}
+		if len(s.Errors) > 0 {
+			fmt.Println("Frequent errors:")
+			for _, e := range s.Errors {
+				fmt.Printf("  %6v  %s\n", e.Count, e.Error)
+			}
+		}
+	}
+}
+
+func writeStatsCSV(rows []*executionStats) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write(statsColumns)
+	for _, s := range rows {
+		w.Write(s.columns())
+	}
+	w.Flush()
+}
+
+func init() {
+	formulaInstanceExecutionsCmd.AddCommand(executionStatsCmd)
+	executionStatsCmd.Flags().IntVar(&statsFormula, "formula", 0, "report on the Instances of this Formula")
+	executionStatsCmd.Flags().StringVar(&statsSince, "since", "30d", "executions created since, as a duration before now (24h, 30d) or a date")
+	executionStatsCmd.Flags().StringVar(&statsUntil, "until", "", "executions created until, as a duration before now or a date")
+	executionStatsCmd.Flags().BoolVar(&statsSteps, "steps", false, "report the slowest steps, fetching every execution's details")
+	executionStatsCmd.Flags().IntVar(&statsTop, "top", 5, "how many slowest steps and errors to report")
+	executionStatsCmd.Flags().BoolVarP(&outputCSV, "csv", "", false, "output as CSV")
+	executionStatsCmd.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+}
@@ -0,0 +1,229 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// exportCanonical writes exports as canonical JSON, see canonicalJSON
+var exportCanonical bool
+
+// Asset types with a canonical serialization policy
+const (
+	assetFormula        = "formula"
+	assetResource       = "resource"
+	assetTransformation = "transformation"
+	assetVDR            = "vdr"
+	assetElement        = "element"
+	assetJob            = "job"
+	assetBranding       = "branding"
+	assetInstance       = "instance"
+)
+
+// canonicalPolicy describes how an asset type is normalized for a diff-friendly export
+type canonicalPolicy struct {
+	// Strip lists, for each place in the document's structure, the keys removed there
+	// as they change without anyone editing the asset. A place is a dotted path of
+	// keys, "*" standing for any array element or object value and "" for the top
+	// level. Keys elsewhere, such as in step properties or request bodies, are kept.
+	Strip map[string][]string
+	// SortBy orders arrays found under a key by the value of a field in each element,
+	// so server-side ordering doesn't show up as a change. The key "" is a top level array.
+	SortBy map[string]string
+}
+
+// Keys the platform assigns to each kind of asset
+var (
+	formulaServerKeys        = []string{"id", "createdDate", "updatedDate", "userId", "accountId"}
+	resourceServerKeys       = []string{"id", "createdDate", "updatedDate", "associatedId", "elementInstanceIds"}
+	transformationServerKeys = []string{"id", "createdDate", "updatedDate", "startDate"}
+	fieldServerKeys          = []string{"id", "associatedId"}
+	datesServerKeys          = []string{"createdDate", "updatedDate"}
+)
+
+// canonicalPolicies are the per-asset-type policies used when exporting with --canonical
+var canonicalPolicies = map[string]canonicalPolicy{
+	assetFormula: {
+		Strip: map[string][]string{
+			"":                formulaServerKeys,
+			"triggers.*":      {"id"},
+			"steps.*":         {"id"},
+			"configuration.*": {"id"},
+		},
+		SortBy: map[string]string{"steps": "name", "configuration": "key"},
+	},
+	assetResource: {
+		Strip: map[string][]string{
+			"":         resourceServerKeys,
+			"fields.*": fieldServerKeys,
+		},
+		SortBy: map[string]string{"fields": "path"},
+	},
+	assetTransformation: {
+		Strip: map[string][]string{
+			"":                transformationServerKeys,
+			"fields.*":        fieldServerKeys,
+			"configuration.*": {"id"},
+		},
+		SortBy: map[string]string{"fields": "path", "configuration": "type"},
+	},
+	assetVDR: {
+		Strip: map[string][]string{
+			"objectDefinitions.*":                 resourceServerKeys,
+			"objectDefinitions.*.fields.*":        fieldServerKeys,
+			"transformations.*.*":                 transformationServerKeys,
+			"transformations.*.*.fields.*":        fieldServerKeys,
+			"transformations.*.*.configuration.*": {"id"},
+		},
+		SortBy: map[string]string{"fields": "path"},
+	},
+	assetElement: {
+		Strip: map[string][]string{
+			"":                datesServerKeys,
+			"resources.*":     datesServerKeys,
+			"parameters.*":    datesServerKeys,
+			"configuration.*": datesServerKeys,
+		},
+		SortBy: map[string]string{"resources": "path", "parameters": "name"},
+	},
+	assetJob: {
+		Strip:  map[string][]string{"*": {"nextFireTime", "previousFireTime"}},
+		SortBy: map[string]string{"": "id"},
+	},
+	assetBranding: {},
+	assetInstance: {
+		Strip:  map[string][]string{"*": datesServerKeys},
+		SortBy: map[string]string{"": "id"},
+	},
+}
+
+// canonicalJSON rewrites a JSON document as indented JSON with sorted keys,
+// applying the policy of the given asset type. Strings, including Formula step
+// scripts, are written back exactly as received and numbers keep their original form.
+func canonicalJSON(data []byte, assetType string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	policy := canonicalPolicies[assetType]
+	doc = normalizeJSON(doc, "", nil, policy.Strip, policy.SortBy)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeJSON removes the keys strip names for each place in the document and
+// sorts arrays according to sortBy. path is where v is in the document.
+// Object keys are ordered by encoding/json when the document is written.
+func normalizeJSON(v interface{}, key string, path []string, strip map[string][]string, sortBy map[string]string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range strippedKeys(path, strip) {
+			delete(t, k)
+		}
+		for k, child := range t {
+			t[k] = normalizeJSON(child, k, append(path[:len(path):len(path)], k), strip, sortBy)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = normalizeJSON(child, key, append(path[:len(path):len(path)], "*"), strip, sortBy)
+		}
+		if field, ok := sortBy[key]; ok {
+			sort.SliceStable(t, func(i, j int) bool {
+				return sortLess(sortValue(t[i], field), sortValue(t[j], field))
+			})
+		}
+		return t
+	}
+	return v
+}
+
+// strippedKeys returns the keys to remove from an object at a path
+func strippedKeys(path []string, strip map[string][]string) []string {
+	var keys []string
+	for place, placeKeys := range strip {
+		if matchPlace(place, path) {
+			keys = append(keys, placeKeys...)
+		}
+	}
+	return keys
+}
+
+// matchPlace reports whether a place such as "steps.*" names a path, where "*"
+// matches any array element or object key
+func matchPlace(place string, path []string) bool {
+	if place == "" {
+		return len(path) == 0
+	}
+	parts := strings.Split(place, ".")
+	if len(parts) != len(path) {
+		return false
+	}
+	for i, p := range parts {
+		if p != "*" && p != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortValue(v interface{}, field string) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m[field]
+	}
+	return nil
+}
+
+// sortLess orders numbers numerically and everything else by its text
+func sortLess(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		if aerr == nil && berr == nil {
+			return af < bf
+		}
+	}
+	return fmt.Sprintf("%v", a) < fmt.Sprintf("%v", b)
+}
+
+// writeExportFile writes an exported asset, as canonical JSON when --canonical is given
+func writeExportFile(filename string, data []byte, assetType string) error {
+	if exportCanonical {
+		canonical, err := canonicalJSON(data, assetType)
+		if err != nil {
+			return fmt.Errorf("unable to canonicalize %s: %s", filename, err.Error())
+		}
+		data = canonical
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
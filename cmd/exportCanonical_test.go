@@ -0,0 +1,100 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		assetType string
+		in        string
+		want      string
+	}{
+		{
+			name:      "keys sorted",
+			assetType: assetBranding,
+			in:        `{"b": 1, "a": {"d": true, "c": null}}`,
+			want:      `{"a":{"c":null,"d":true},"b":1}`,
+		},
+		{
+			name:      "arrays sorted by the policy's field",
+			assetType: assetFormula,
+			in:        `{"name": "f", "steps": [{"name": "b"}, {"name": "a"}], "configuration": [{"key": "z"}, {"key": "y"}], "triggers": [{"type": "b"}, {"type": "a"}]}`,
+			want:      `{"configuration":[{"key":"y"},{"key":"z"}],"name":"f","steps":[{"name":"a"},{"name":"b"}],"triggers":[{"type":"b"},{"type":"a"}]}`,
+		},
+		{
+			name:      "top level arrays sorted numerically",
+			assetType: assetJob,
+			in:        `[{"id": 10}, {"id": 9}, {"id": 100}]`,
+			want:      `[{"id":9},{"id":10},{"id":100}]`,
+		},
+		{
+			name:      "server keys stripped only where the policy says",
+			assetType: assetFormula,
+			in:        `{"id": 1, "createdDate": "x", "name": "f", "steps": [{"id": 2, "name": "a", "properties": {"id": "kept", "body": "done({id: 1});"}}], "triggers": [{"id": 3, "type": "manual", "properties": {"id": "kept"}}]}`,
+			want:      `{"name":"f","steps":[{"name":"a","properties":{"body":"done({id: 1});","id":"kept"}}],"triggers":[{"properties":{"id":"kept"},"type":"manual"}]}`,
+		},
+		{
+			name:      "wildcard places",
+			assetType: assetVDR,
+			in:        `{"objectDefinitions": {"contact": {"id": 1, "fields": [{"id": 2, "path": "b"}, {"id": 3, "path": "a", "vendorPath": "id"}]}}, "transformations": {"sfdc": {"contact": {"id": 4, "vendorName": "Contact"}}}}`,
+			want:      `{"objectDefinitions":{"contact":{"fields":[{"path":"a","vendorPath":"id"},{"path":"b"}]}},"transformations":{"sfdc":{"contact":{"vendorName":"Contact"}}}}`,
+		},
+		{
+			name:      "numbers keep their form",
+			assetType: assetBranding,
+			in:        `{"big": 12345678901234567890, "float": 1.50, "exp": 1e3}`,
+			want:      `{"big":12345678901234567890,"exp":1e3,"float":1.50}`,
+		},
+		{
+			name:      "no HTML escaping",
+			assetType: assetFormula,
+			in:        `{"steps": [{"name": "a", "properties": {"body": "if (a < b && c > d) done('<p>');"}}]}`,
+			want:      `{"steps":[{"name":"a","properties":{"body":"if (a < b && c > d) done('<p>');"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalJSON([]byte(tt.in), tt.assetType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, got); err != nil {
+				t.Fatal(err)
+			}
+			if compact.String() != tt.want {
+				t.Errorf("canonicalJSON() =\n  %s\nwant\n  %s", compact.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSONIndented(t *testing.T) {
+	got, err := canonicalJSON([]byte(`{"b":[1,2],"a":"x"}`), assetBranding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"a\": \"x\",\n  \"b\": [\n    1,\n    2\n  ]\n}\n"
+	if string(got) != want {
+		t.Errorf("canonicalJSON() = %q, want %q", got, want)
+	}
+}
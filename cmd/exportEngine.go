@@ -0,0 +1,328 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+// exportConcurrency is the maximum number of concurrent API calls made during an export
+var exportConcurrency = 4
+
+// forEachConcurrently calls fn for each index in [0, count) using at most
+// exportConcurrency goroutines, returning once all calls have completed
+func forEachConcurrently(count int, fn func(i int)) {
+	workers := exportConcurrency
+	if workers < 1 { // guard against 0
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// callPool bounds the API calls in flight across several loops that run at the
+// same time, where forEachConcurrently only bounds a single loop
+type callPool chan struct{}
+
+// newCallPool returns a pool of exportConcurrency slots
+func newCallPool() callPool {
+	size := exportConcurrency
+	if size < 1 { // guard against 0
+		size = 1
+	}
+	return make(callPool, size)
+}
+
+// do calls fn once a slot is free
+func (p callPool) do(fn func()) {
+	p <- struct{}{}
+	defer func() { <-p }()
+	fn()
+}
+
+// forEach calls fn for each index in [0, count), each in a slot of the pool,
+// returning once all calls have completed. fn must not use the pool itself.
+func (p callPool) forEach(count int, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		p <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-p }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// exportProgress logs progress through a set of export calls
+type exportProgress struct {
+	label string
+	total int
+	done  int32
+}
+
+func newExportProgress(label string, total int) *exportProgress {
+	if total > 0 {
+		log.Printf("%s: fetching %v", label, total)
+	}
+	return &exportProgress{label: label, total: total}
+}
+
+// step records that one item has been fetched
+func (p *exportProgress) step(name string) {
+	done := atomic.AddInt32(&p.done, 1)
+	log.Printf("%s: [%v/%v] %s", p.label, done, p.total, name)
+}
+
+// ExportErrors holds the failures of individual calls made during an export,
+// which are collected rather than ending the export early
+type ExportErrors []error
+
+func (e ExportErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%v export call(s) failed:\n  %s", len(e), strings.Join(messages, "\n  "))
+}
+
+// exportErrorCollector gathers errors from concurrent export calls
+type exportErrorCollector struct {
+	sync.Mutex
+	errs ExportErrors
+}
+
+func (c *exportErrorCollector) add(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+// err returns the collected errors as ExportErrors, or nil if there were none
+func (c *exportErrorCollector) err() error {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.errs) == 0 {
+		return nil
+	}
+	sort.Slice(c.errs, func(i, j int) bool { return c.errs[i].Error() < c.errs[j].Error() })
+	return c.errs
+}
+
+// mergeExportErrors combines errors from several export stages into one
+func mergeExportErrors(errs ...error) error {
+	var merged ExportErrors
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(ExportErrors); ok {
+			merged = append(merged, e...)
+		} else {
+			merged = append(merged, err)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// fetchResourceDefinitions retrieves the definition of every Common Resource
+// selected by exportSelection, keyed by Resource name. Resources that cannot be
+// retrieved are reported in the returned ExportErrors and omitted from the map.
+// Calls are made in slots of pool.
+func fetchResourceDefinitions(base, auth string, pool callPool) (map[string][]byte, error) {
+	var resourcesListBytes []byte
+	var status int
+	var err error
+	pool.do(func() {
+		resourcesListBytes, status, _, err = ce.ResourcesList(base, auth)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("HTTP Status Code listing Resources: %v", status)
+	}
+	var all []ce.CommonResource
+	err = json.Unmarshal(resourcesListBytes, &all)
+	if err != nil {
+		return nil, err
+	}
+	var resources []ce.CommonResource
+	for _, r := range all {
+		if exportSelection.includesName(r.Name) {
+			resources = append(resources, r)
+		}
+	}
+
+	definitions := make(map[string][]byte)
+	var mu sync.Mutex
+	var errs exportErrorCollector
+	progress := newExportProgress("Resources", len(resources))
+	pool.forEach(len(resources), func(i int) {
+		name := resources[i].Name
+		resourceBytes, status, _, err := ce.GetResourceDefinition(base, auth, name, false)
+		if err != nil {
+			errs.add(fmt.Errorf("Resource %s: %s", name, err.Error()))
+			return
+		}
+		if status != 200 {
+			errs.add(fmt.Errorf("Resource %s: HTTP Status Code %v", name, status))
+			return
+		}
+		mu.Lock()
+		definitions[name] = resourceBytes
+		mu.Unlock()
+		progress.step(name)
+	})
+
+	return definitions, errs.err()
+}
+
+// fetchElementTransformations retrieves the Transformations of every Element that
+// has at least one Transformation associated with it, keyed by Element key and then
+// by Resource name. Each Element is only looked up once, however many Transformations
+// it is associated with. Elements and Resource names are limited by exportSelection.
+// Calls are made in slots of pool.
+func fetchElementTransformations(base, auth string, pool callPool) (map[string]map[string]interface{}, error) {
+	txs := make(map[string]map[string]interface{})
+
+	var bodybytes []byte
+	var status int
+	var err error
+	pool.do(func() {
+		bodybytes, status, _, err = ce.GetTransformations(base, auth)
+	})
+	if err != nil {
+		log.Println("Couldn't find any Transformations")
+		return txs, err
+	}
+	if status != 200 {
+		log.Println("No Transformations present")
+		return txs, nil
+	}
+	transformationnames := make(map[string]ce.Transformation)
+	err = json.Unmarshal(bodybytes, &transformationnames)
+	if err != nil {
+		return txs, fmt.Errorf("Couldn't unmarshal list of Transformation names: %s", err.Error())
+	}
+	var names []string
+	for k := range transformationnames {
+		if exportSelection.includesName(k) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	// Assemble unique Elements across all Transformation associations
+	var mu sync.Mutex
+	var errs exportErrorCollector
+	namemap := make(map[int]string)
+	progress := newExportProgress("Transformation associations", len(names))
+	pool.forEach(len(names), func(i int) {
+		bodybytes, status, _, err := ce.GetTransformationAssocation(base, auth, names[i])
+		if err != nil {
+			errs.add(fmt.Errorf("Transformation %s associations: %s", names[i], err.Error()))
+			return
+		}
+		if status != 200 {
+			errs.add(fmt.Errorf("Transformation %s associations: HTTP Status Code %v", names[i], status))
+			return
+		}
+		var associations []ce.AccountElement
+		err = json.Unmarshal(bodybytes, &associations)
+		if err != nil {
+			errs.add(fmt.Errorf("Transformation %s associations: %s", names[i], err.Error()))
+			return
+		}
+		mu.Lock()
+		for _, v := range associations {
+			if exportSelection.includesElement(v.Element.Key) {
+				namemap[v.Element.ID] = v.Element.Key
+			}
+		}
+		mu.Unlock()
+		progress.step(names[i])
+	})
+	var elementids []int
+	for id := range namemap {
+		elementids = append(elementids, id)
+	}
+	sort.Ints(elementids)
+
+	// Retrieve the Transformations for each Element
+	progress = newExportProgress("Element Transformations", len(elementids))
+	pool.forEach(len(elementids), func(i int) {
+		key := namemap[elementids[i]]
+		idstr := strconv.Itoa(elementids[i])
+		bodybytes, status, _, err := ce.GetTransformationsPerElement(base, auth, idstr)
+		if err != nil {
+			errs.add(fmt.Errorf("Element %s (%s) Transformations: %s", key, idstr, err.Error()))
+			return
+		}
+		if status != 200 {
+			errs.add(fmt.Errorf("Element %s (%s) Transformations: HTTP Status Code %v", key, idstr, status))
+			return
+		}
+		transforms := make(map[string]interface{})
+		err = json.Unmarshal(bodybytes, &transforms)
+		if err != nil {
+			errs.add(fmt.Errorf("Element %s (%s) Transformations: %s", key, idstr, err.Error()))
+			return
+		}
+		for name := range transforms {
+			if !exportSelection.includesName(name) {
+				delete(transforms, name)
+			}
+		}
+		mu.Lock()
+		txs[key] = transforms
+		mu.Unlock()
+		progress.step(fmt.Sprintf("%s (%s)", key, idstr))
+	})
+
+	return txs, errs.err()
+}
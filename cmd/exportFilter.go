@@ -0,0 +1,131 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// namePattern matches an asset name either as a glob (default) or,
+// when written as /pattern/, as a regular expression
+type namePattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func parseNamePattern(p string) (namePattern, error) {
+	if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+		re, err := regexp.Compile(p[1 : len(p)-1])
+		if err != nil {
+			return namePattern{}, fmt.Errorf("invalid regular expression %s: %s", p, err.Error())
+		}
+		return namePattern{regex: re}, nil
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return namePattern{}, fmt.Errorf("invalid glob %s: %s", p, err.Error())
+	}
+	return namePattern{glob: p}, nil
+}
+
+func (p namePattern) match(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// exportFilter selects which assets an export includes. The zero value selects everything.
+type exportFilter struct {
+	include    []namePattern
+	exclude    []namePattern
+	elements   map[string]bool
+	activeOnly bool
+}
+
+// exportSelection is the filter applied by the export functions
+var exportSelection exportFilter
+
+var (
+	exportInclude, exportExclude, exportElements []string
+	exportActiveOnly                             bool
+)
+
+// newExportFilter builds an exportFilter from include/exclude patterns and Element keys
+func newExportFilter(include, exclude, elements []string, activeOnly bool) (exportFilter, error) {
+	f := exportFilter{activeOnly: activeOnly}
+	for _, v := range include {
+		p, err := parseNamePattern(v)
+		if err != nil {
+			return f, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, v := range exclude {
+		p, err := parseNamePattern(v)
+		if err != nil {
+			return f, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	if len(elements) > 0 {
+		f.elements = make(map[string]bool)
+		for _, v := range elements {
+			f.elements[v] = true
+		}
+	}
+	return f, nil
+}
+
+// includesName reports whether a Formula or Resource name passes the include and exclude patterns
+func (f exportFilter) includesName(name string) bool {
+	if len(f.include) > 0 {
+		included := false
+		for _, p := range f.include {
+			if p.match(name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, p := range f.exclude {
+		if p.match(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// includesElement reports whether Transformations for an Element key should be exported
+func (f exportFilter) includesElement(key string) bool {
+	if f.elements == nil {
+		return true
+	}
+	return f.elements[key]
+}
+
+// includesFormula reports whether a Formula should be exported
+func (f exportFilter) includesFormula(name string, active bool) bool {
+	if f.activeOnly && !active {
+		return false
+	}
+	return f.includesName(name)
+}
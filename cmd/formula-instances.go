@@ -15,13 +15,18 @@
 package cmd
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
 
@@ -39,8 +44,12 @@ var createInstanceCmd = &cobra.Command{
 	Use:   "create <id> [name]",
 	Short: "creates an instance of a Formula, given a Formula ID",
 	Long: `Given the ID of Formula template, create an Instance of the Formula
-Optionally, provide the Formula configuration definition via a flag.
-A name for the Formula Instance will be required when using a flag.`,
+Optionally, provide the Formula configuration via --configuration, as JSON or
+a JSON file. The configuration is checked against the Formula's declarations:
+required values, value types and that Element Instances exist in the account,
+and all problems are reported together.
+Without --configuration on a terminal, each declared value is prompted for, as
+is the name if it isn't given; otherwise a name is required.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			fmt.Println("Please supply an ID of a Formula template\ncectl formula-instance create <ID> [name]")
@@ -55,22 +64,75 @@ A name for the Formula Instance will be required when using a flag.`,
 			os.Exit(1)
 		}
 
-		// if no instance config json given, check for name
-		var config ce.FormulaInstanceConfig
-		// formulaInstanceConfiguration will be set if the flag --configuration has a value (which should be a JSON filename)
-		if formulaInstanceConfiguration == "" {
-			if len(args) < 2 {
-				fmt.Println("Please provide a name for the Instance if not submitting a Formula Instance configuration definition\ncectl formula-instance create <ID> [name]")
+		formulabytes, err := pullFormula(args[0], profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		f, err := formula.Parse(formulabytes)
+		if err != nil {
+			fmt.Println("Unable to understand formula response", err.Error())
+			os.Exit(1)
+		}
+
+		var instances map[int]ce.ElementInstance
+		if formulaDeclaresElementInstances(f) {
+			instances, err = accountElementInstances(profilemap["base"], profilemap["auth"])
+			if err != nil {
+				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			config = ce.FormulaInstanceConfig{Name: args[1], Active: true}
-		} else {
-			var raw map[string]interface{}
-			_ = json.Unmarshal([]byte(formulaInstanceConfiguration), &raw)
-			config = ce.FormulaInstanceConfig{Name: args[1], Active: true, Configuration: raw}
 		}
 
+		interactive := isTerminal(os.Stdin)
+		in := bufio.NewReader(os.Stdin)
+
+		var name string
+		if len(args) > 1 {
+			name = args[1]
+		}
+
+		// formulaInstanceConfiguration will be set if the flag --configuration has a value, JSON or a JSON filename
+		values := make(map[string]interface{})
+		if formulaInstanceConfiguration != "" {
+			values, err = readConfigurationArg(formulaInstanceConfiguration)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		} else if interactive && len(f.Configuration) > 0 {
+			fmt.Printf("Configuration of %s:\n", f.Name)
+			values, err = promptConfiguration(in, f, instances)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		if name == "" && interactive {
+			name, err = promptLine(in, "Formula Instance name")
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		if name == "" {
+			fmt.Println("Please provide a name for the Instance\ncectl formula-instance create <ID> [name]")
+			os.Exit(1)
+		}
+
+		problems := checkInstanceConfiguration(f, values, instances)
+		if len(problems) > 0 {
+			printConfigurationProblems(problems)
+			os.Exit(1)
+		}
+
+		config := ce.FormulaInstanceConfig{Name: name, Active: true, Configuration: values}
 		bodybytes, status, curlcmd, err := ce.CreateFormulaInstance(profilemap["base"], profilemap["auth"], args[0], config)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 
 		if showCurl {
 			log.Println(curlcmd)
@@ -82,7 +144,10 @@ A name for the Formula Instance will be required when using a flag.`,
 		// handle non 200
 		if status != 200 {
 			log.Printf("HTTP Error: %v\n", status)
-			// handle this nicely, show error description
+			var ficr ce.FormulaInstanceCreationResponse
+			if json.Unmarshal(bodybytes, &ficr) == nil && ficr.Message != "" {
+				fmt.Println(ficr.Message)
+			}
 			os.Exit(1)
 		}
 		var response map[string]interface{}
@@ -98,6 +163,10 @@ A name for the Formula Instance will be required when using a flag.`,
 var (
 	triggerBody       string
 	triggerTextOutput bool
+	triggerWait       bool
+	triggerTimeout    time.Duration
+	triggerInterval   time.Duration
+	triggerOutput     string
 )
 
 // triggerCmd represents the trigger command
@@ -106,13 +175,32 @@ var triggerCmd = &cobra.Command{
 	Short: "invoke a Formula Instance",
 	Long: `Invokes a Formula Instance by ID, resulting in a Formula Instance Execution.
 The trigger body is optional (defaults to: {}).
-This will only invoke a manually triggerable Formula.`,
+This will only invoke a manually triggerable Formula.
+With --wait, the execution is followed until it finishes, printing each step
+as it completes, then a summary of the steps; the exit status is 1 if the
+execution didn't succeed and 2 if it didn't finish within --timeout.
+--output json prints the final execution document instead of the summary.
+With --data-file, a trigger is sent for each record of a file: a JSON document
+per line, or each row of a CSV file with a header. CSV columns become fields of
+the body named by their header, or are placed with --map column=path.to.field.
+Triggers are sent --concurrency at a time, at most --rate a second if given, and
+the execution ID or error for each line is written to --results.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			fmt.Println("must supply an ID of a Formula Instance")
 			os.Exit(1)
 		}
 
+		if triggerOutput != "table" && triggerOutput != "json" {
+			fmt.Println("--output must be table or json")
+			os.Exit(1)
+		}
+
+		if triggerDataFile != "" && triggerWait {
+			fmt.Println("--wait can't be used with --data-file")
+			os.Exit(1)
+		}
+
 		// check for profile
 		profilemap, err := getAuth(profile)
 		if err != nil {
@@ -120,6 +208,10 @@ This will only invoke a manually triggerable Formula.`,
 			os.Exit(1)
 		}
 
+		if triggerDataFile != "" {
+			os.Exit(triggerBatch(args[0], profilemap))
+		}
+
 		bodybytes, status, curlcmd, err := ce.TriggerFormulaInstance(profilemap["base"], profilemap["auth"], args[0], triggerBody)
 
 		if outputJSON {
@@ -136,24 +228,108 @@ This will only invoke a manually triggerable Formula.`,
 			err = json.Unmarshal(bodybytes, &ex)
 			fmt.Printf("%s\nID: %v (%s)\n", ex.Message, args[0], ex.RequestID)
 			fmt.Println(status)
+			if triggerWait {
+				os.Exit(1)
+			}
 			return
 		}
 
 		var ex []ce.FormulaInstanceCreationResponse
 		err = json.Unmarshal(bodybytes, &ex)
-		if err != nil { // that's not an array of responses
-			log.Println(err.Error())
+		if err != nil || len(ex) == 0 { // that's not an array of responses
+			log.Println("no execution in trigger response", string(bodybytes))
+			if triggerWait {
+				os.Exit(1)
+			}
 			return
 		}
 
-		if triggerTextOutput {
+		switch {
+		case triggerTextOutput && triggerWait:
+			// progress and the summary follow on stderr
+			fmt.Println(ex[0].ID)
+		case triggerTextOutput:
 			fmt.Print(ex[0].ID)
-		} else {
+		case triggerWait && triggerOutput == "json":
+			fmt.Fprintf(os.Stderr, "Execution ID: %v\n", ex[0].ID)
+		default:
 			fmt.Printf("Execution ID: %v\n", ex[0].ID)
 		}
+
+		if triggerWait {
+			os.Exit(waitForExecution(strconv.Itoa(ex[0].ID), profilemap))
+		}
 	},
 }
 
+// waitForExecution polls an execution until it finishes, reporting steps as they
+// complete, and returns the exit status for its outcome
+func waitForExecution(id string, profilemap map[string]string) int {
+	// keep stdout for the execution ID or document when that's all that's wanted
+	progress := os.Stdout
+	if triggerOutput == "json" || triggerTextOutput {
+		progress = os.Stderr
+	}
+
+	var deadline time.Time
+	if triggerTimeout > 0 {
+		deadline = time.Now().Add(triggerTimeout)
+	}
+	reported := make(map[int]string)
+	for {
+		ex, exbytes, err := fetchExecution(id, profilemap["base"], profilemap["auth"])
+		if err != nil {
+			fmt.Fprintln(progress, err.Error())
+			// a refusal such as 401, 403 or 404 won't go away by asking again
+			if se, ok := err.(*executionStatusError); ok && !se.transient() {
+				return 1
+			}
+		} else {
+			for _, s := range ex.StepExecutions {
+				if executionFinished(s.Status) && reported[s.ID] != s.Status {
+					fmt.Fprintf(progress, "  %-30s %s\n", s.StepName, s.Status)
+					reported[s.ID] = s.Status
+				}
+			}
+			if executionFinished(ex.Status) {
+				if triggerOutput == "json" {
+					fmt.Printf("%s\n", exbytes)
+				} else {
+					printExecutionSummary(progress, ex)
+				}
+				if ex.Status != executionSuccess {
+					return 1
+				}
+				return 0
+			}
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			fmt.Fprintf(progress, "Execution %s didn't finish within %s\n", id, triggerTimeout)
+			return 2
+		}
+		time.Sleep(triggerInterval)
+	}
+}
+
+// printExecutionSummary prints the outcome of an execution and each of its steps
+func printExecutionSummary(w io.Writer, ex *formulaExecution) {
+	fmt.Fprintf(w, "Execution %v %s in %s\n", ex.ID, ex.Status, executionDuration(ex.Status, ex.CreatedDate, ex.UpdatedDate))
+	data := [][]string{}
+	for _, s := range ex.StepExecutions {
+		data = append(data, []string{
+			s.StepName,
+			s.Status,
+			s.CreatedDate.String(),
+			executionDuration(s.Status, s.CreatedDate, s.UpdatedDate),
+		})
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Step", "Status", "Started", "Duration"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
 var deleteFormulaInstanceCmd = &cobra.Command{
 	Use:   "delete <id>",
 	Short: "deletes a Formula Instance",
@@ -210,12 +386,21 @@ func init() {
 	formulaInstancesCmd.AddCommand(triggerCmd)
 	triggerCmd.Flags().StringVarP(&triggerBody, "data", "d", "{}", "data for trigger body")
 	triggerCmd.Flags().BoolVarP(&triggerTextOutput, "text", "t", false, "output trigger id as text")
+	triggerCmd.Flags().BoolVarP(&triggerWait, "wait", "w", false, "wait for the execution to finish")
+	triggerCmd.Flags().DurationVar(&triggerTimeout, "timeout", 10*time.Minute, "how long to wait for the execution, 0 for no limit")
+	triggerCmd.Flags().DurationVar(&triggerInterval, "interval", 2*time.Second, "how often to check the execution while waiting")
+	triggerCmd.Flags().StringVarP(&triggerOutput, "output", "o", "table", "result of a waited for execution: table or json")
+	triggerCmd.Flags().StringVar(&triggerDataFile, "data-file", "", "send a trigger for each record of an NDJSON or CSV file")
+	triggerCmd.Flags().StringSliceVar(&triggerCSVMap, "map", nil, "CSV column=path.in.body, comma separated or repeated")
+	triggerCmd.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent triggers with --data-file")
+	triggerCmd.Flags().Float64Var(&triggerRate, "rate", 0, "max triggers a second with --data-file, 0 for no limit")
+	triggerCmd.Flags().StringVar(&triggerResults, "results", "", "results file for --data-file (default <data-file>.results.ndjson)")
 
 	formulaInstancesCmd.AddCommand(deleteFormulaInstanceCmd)
 
 	formulaInstancesCmd.AddCommand(createInstanceCmd)
 
-	createInstanceCmd.Flags().StringVarP(&formulaInstanceConfiguration, "configuration", "", "", "instance configuration definition")
+	createInstanceCmd.Flags().StringVarP(&formulaInstanceConfiguration, "configuration", "", "", "instance configuration, as JSON or a JSON file")
 	// deprecated
 	createInstanceCmd.Flags().StringVarP(&formulaInstanceConfiguration, "instance", "i", "", "instance configuration definition")
 
@@ -0,0 +1,346 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	activationIDs     []int
+	activationMatch   string
+	activationUses    string
+	activationAll     bool
+	activationDryRun  bool
+	activationSave    string
+	activationRestore string
+)
+
+const activationLong = `The Formulas are given by ID as arguments or with --ids 1,2,3, by name with
+--match <glob> (or /regex/), as every Formula depending on an Element key or
+Resource name with --uses, or with --all. --dry-run shows what would change.
+Formulas are updated concurrently, bounded by --concurrency, and a report shows
+each Formula's state before and after.
+--save <file> records the state of the Formulas changed, so that the same set
+can be put back later with --restore <file>, which sets each Formula in the file
+to the state recorded, whichever of activate or deactivate is run.`
+
+// formulaActivateCmd activates Formula templates
+var formulaActivateCmd = &cobra.Command{
+	Use:   "activate [id...]",
+	Short: "Activate Formula templates",
+	Long:  "Sets Formula templates to an active state.\n" + activationLong,
+	Run: func(cmd *cobra.Command, args []string) {
+		setFormulasActive(args, true)
+	},
+}
+
+// formulaDeactivateCmd deactivates Formula templates
+var formulaDeactivateCmd = &cobra.Command{
+	Use:   "deactivate [id...]",
+	Short: "Deactivate Formula templates",
+	Long:  "Sets Formula templates to an inactive state.\n" + activationLong,
+	Run: func(cmd *cobra.Command, args []string) {
+		setFormulasActive(args, false)
+	},
+}
+
+// activationState records the active state of Formulas, to be restored later
+type activationState struct {
+	Profile  string                 `json:"profile"`
+	Saved    time.Time              `json:"saved"`
+	Formulas []activationStateEntry `json:"formulas"`
+}
+
+type activationStateEntry struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+}
+
+// activationChange is the outcome of setting one Formula's state
+type activationChange struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Before bool   `json:"before"`
+	After  bool   `json:"after"`
+	Result string `json:"result"`
+	Err    string `json:"error,omitempty"`
+}
+
+// setFormulasActive sets the selected Formulas to active, or to the states of a restore file
+func setFormulasActive(args []string, active bool) {
+	profilemap, err := getAuth(profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	base := profilemap["base"]
+	auth := profilemap["auth"]
+
+	formulas, err := listFormulas(base, auth)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	// the state each selected Formula should end up in
+	targets, err := activationTargets(args, active, formulas, base, auth)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if len(targets) == 0 {
+		fmt.Println("No Formulas selected")
+		os.Exit(1)
+	}
+
+	byID := make(map[int]ce.Formula)
+	for _, f := range formulas {
+		byID[f.ID] = f
+	}
+	changes := make([]activationChange, len(targets))
+	for i, t := range targets {
+		f, ok := byID[t.ID]
+		changes[i] = activationChange{ID: t.ID, Name: f.Name, Before: f.Active, After: t.Active}
+		switch {
+		case !ok:
+			changes[i].Name = t.Name
+			changes[i].Result = "failed"
+			changes[i].Err = "no such Formula"
+		case f.Active == t.Active:
+			changes[i].Result = "unchanged"
+		case activationDryRun:
+			changes[i].Result = "would change"
+		}
+	}
+
+	if activationSave != "" && !activationDryRun {
+		err = saveActivationState(activationSave, changes)
+		if err != nil {
+			fmt.Println("Unable to save state, no Formulas changed:", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	forEachConcurrently(len(changes), func(i int) {
+		if changes[i].Result != "" {
+			return
+		}
+		err := updateFormulaActive(changes[i].ID, changes[i].After, base, auth)
+		if err != nil {
+			changes[i].Result = "failed"
+			changes[i].Err = err.Error()
+			changes[i].After = changes[i].Before
+			return
+		}
+		changes[i].Result = "changed"
+	})
+
+	failed := 0
+	for _, c := range changes {
+		if c.Result == "failed" {
+			failed++
+		}
+	}
+	if outputJSON {
+		changebytes, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Printf("%s\n", changebytes)
+	} else {
+		data := [][]string{}
+		for _, c := range changes {
+			data = append(data, []string{
+				strconv.Itoa(c.ID),
+				c.Name,
+				strconv.FormatBool(c.Before),
+				strconv.FormatBool(c.After),
+				strings.TrimSpace(c.Result + " " + c.Err),
+			})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"ID", "Name", "before", "after", "result"})
+		table.SetBorder(false)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// listFormulas returns every Formula of the account
+func listFormulas(base, auth string) ([]ce.Formula, error) {
+	bodybytes, statuscode, curlcmd, err := ce.FormulasList(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if statuscode != 200 {
+		return nil, fmt.Errorf("unable to list formulas, HTTP %v", statuscode)
+	}
+	var formulas []ce.Formula
+	err = json.Unmarshal(bodybytes, &formulas)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(formulas, func(i, j int) bool { return formulas[i].ID < formulas[j].ID })
+	return formulas, nil
+}
+
+// activationTargets returns the selected Formulas with the state each should be set to
+func activationTargets(args []string, active bool, formulas []ce.Formula, base, auth string) ([]activationStateEntry, error) {
+	if activationRestore != "" {
+		var state activationState
+		err := readJSONFile(activationRestore, &state)
+		if err != nil {
+			return nil, err
+		}
+		if state.Profile != "" && state.Profile != profile {
+			log.Printf("%s was saved from profile %s, restoring to %s", activationRestore, state.Profile, profile)
+		}
+		return state.Formulas, nil
+	}
+
+	selected := make(map[int]bool)
+	ids := activationIDs
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a Formula ID", arg)
+		}
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	if activationMatch != "" {
+		pattern, err := parseNamePattern(activationMatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range formulas {
+			if pattern.match(f.Name) {
+				selected[f.ID] = true
+			}
+		}
+	}
+
+	if activationUses != "" {
+		acct, err := loadDepsAccount(base, auth)
+		if err != nil {
+			return nil, err
+		}
+		deps, err := analyzeFormulas(base, auth, acct.formulas, acct)
+		if err != nil {
+			// a Formula that couldn't be analyzed might be missed, so don't carry on
+			return nil, err
+		}
+		for _, d := range deps {
+			if len(d.uses(activationUses)) > 0 {
+				selected[d.ID] = true
+			}
+		}
+	}
+
+	var targets []activationStateEntry
+	for _, f := range formulas {
+		if activationAll || selected[f.ID] {
+			targets = append(targets, activationStateEntry{ID: f.ID, Name: f.Name, Active: active})
+			delete(selected, f.ID)
+		}
+	}
+	// IDs that aren't Formulas of the account are reported as failures
+	for id := range selected {
+		targets = append(targets, activationStateEntry{ID: id, Active: active})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].ID < targets[j].ID })
+	return targets, nil
+}
+
+// saveActivationState writes the state before the change of the Formulas that will change
+func saveActivationState(filename string, changes []activationChange) error {
+	state := activationState{Profile: profile, Saved: time.Now().UTC()}
+	for _, c := range changes {
+		if c.Result == "" {
+			state.Formulas = append(state.Formulas, activationStateEntry{ID: c.ID, Name: c.Name, Active: c.Before})
+		}
+	}
+	statebytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, statebytes, 0644)
+}
+
+// updateFormulaActive sets a single Formula's active state
+func updateFormulaActive(id int, active bool, base, auth string) error {
+	formulaResponseBytes, statuscode, curlcmd, err := ce.FormulaDetailsAsBytes(strconv.Itoa(id), base, auth)
+	if err != nil {
+		return err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if statuscode != 200 {
+		return fmt.Errorf("unable to retrieve formula, HTTP %v", statuscode)
+	}
+	var f ce.Formula
+	err = json.Unmarshal(formulaResponseBytes, &f)
+	if err != nil {
+		return fmt.Errorf("unable to understand formula response, %s", err.Error())
+	}
+
+	f.Active = active
+	patchBytes, statuscode, err := ce.FormulaUpdate(strconv.Itoa(id), base, auth, f)
+	if err != nil {
+		return err
+	}
+	if statuscode != 200 {
+		var ficr ce.FormulaInstanceCreationResponse
+		if json.Unmarshal(patchBytes, &ficr) == nil && ficr.Message != "" {
+			return fmt.Errorf("HTTP %v: %s", statuscode, ficr.Message)
+		}
+		return fmt.Errorf("HTTP %v", statuscode)
+	}
+	return nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{formulaActivateCmd, formulaDeactivateCmd} {
+		c.Flags().IntSliceVar(&activationIDs, "ids", nil, "IDs of the Formulas, comma separated")
+		c.Flags().StringVar(&activationMatch, "match", "", "select Formulas whose name matches a glob or /regex/")
+		c.Flags().StringVar(&activationUses, "uses", "", "select Formulas that depend on an Element key or Resource name")
+		c.Flags().BoolVar(&activationAll, "all", false, "select every Formula")
+		c.Flags().BoolVar(&activationDryRun, "dry-run", false, "show what would change without changing anything")
+		c.Flags().StringVar(&activationSave, "save", "", "save the state of the Formulas changed to this file")
+		c.Flags().StringVar(&activationRestore, "restore", "", "set Formulas to the states saved in this file")
+		c.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+// formulaExecution is a Formula Instance Execution as the platform details it,
+// with the execution of each step
+type formulaExecution struct {
+	ID                int             `json:"id"`
+	FormulaInstanceID int             `json:"formulaInstanceId"`
+	Status            string          `json:"status"`
+	CreatedDate       time.Time       `json:"createdDate"`
+	UpdatedDate       time.Time       `json:"updatedDate"`
+	StepExecutions    []stepExecution `json:"stepExecutions"`
+}
+
+// stepExecution is one run of a step within a Formula Instance Execution
+type stepExecution struct {
+	ID                  int                  `json:"id"`
+	StepName            string               `json:"stepName"`
+	Status              string               `json:"status"`
+	CreatedDate         time.Time            `json:"createdDate"`
+	UpdatedDate         time.Time            `json:"updatedDate"`
+	StepExecutionValues []stepExecutionValue `json:"stepExecutionValues"`
+}
+
+// stepExecutionValue is a value a step execution recorded, such as its request or response
+type stepExecutionValue struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// text returns a value as text, indented if it's JSON. The platform gives most
+// values as strings of JSON.
+func (v stepExecutionValue) text() string {
+	var s string
+	if json.Unmarshal(v.Value, &s) != nil {
+		s = string(v.Value)
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, []byte(s), "", "  ") == nil {
+		return pretty.String()
+	}
+	return s
+}
+
+// triggerStep is the name of the step execution that records the trigger
+const triggerStep = "trigger"
+
+// Statuses of executions and step executions
+const (
+	executionSuccess = "success"
+	executionFailed  = "failed"
+	executionPending = "pending"
+)
+
+// executionFinished reports whether an execution status is terminal
+func executionFinished(status string) bool {
+	switch status {
+	case "", executionPending, "running", "queued":
+		return false
+	}
+	return true
+}
+
+// executionStatusError is returned by fetchExecution when the platform
+// refuses to return an execution
+type executionStatusError struct {
+	status int
+	msg    string
+}
+
+func (e *executionStatusError) Error() string {
+	return e.msg
+}
+
+// transient reports whether trying again later could succeed
+func (e *executionStatusError) transient() bool {
+	return e.status == http.StatusRequestTimeout || e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// fetchExecution retrieves the details of a Formula Instance Execution,
+// returning the document as the platform sent it too
+func fetchExecution(id string, base, auth string) (*formulaExecution, []byte, error) {
+	bodybytes, status, curlcmd, err := ce.GetFormulaInstanceExecutionID(id, base, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return nil, bodybytes, &executionStatusError{
+			status: status,
+			msg:    fmt.Sprintf("unable to get execution %s, %s", id, platformError(status, bodybytes).Error()),
+		}
+	}
+	var ex formulaExecution
+	err = json.Unmarshal(bodybytes, &ex)
+	if err != nil {
+		return nil, bodybytes, fmt.Errorf("unable to understand execution %s, %s", id, err.Error())
+	}
+	ex.sortSteps()
+	return &ex, bodybytes, nil
+}
+
+// sortSteps puts the step executions in the order they ran
+func (ex *formulaExecution) sortSteps() {
+	sort.SliceStable(ex.StepExecutions, func(i, j int) bool {
+		a, b := ex.StepExecutions[i], ex.StepExecutions[j]
+		if !a.CreatedDate.Equal(b.CreatedDate) {
+			return a.CreatedDate.Before(b.CreatedDate)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// executionDuration is the time between creation and last update, or "pending"
+// while an execution or step hasn't finished
+func executionDuration(status string, created, updated time.Time) string {
+	diff := updated.Sub(created)
+	if !executionFinished(status) || diff < 0 {
+		return executionPending
+	}
+	return diff.String()
+}
+
+// stepError returns the error a failed step execution recorded, or all its
+// values if none of them is an error
+func (s stepExecution) stepError() string {
+	var all []string
+	for _, v := range s.StepExecutionValues {
+		key := strings.ToLower(v.Key)
+		if strings.HasSuffix(key, "error") || strings.HasSuffix(key, "message") {
+			return v.text()
+		}
+		all = append(all, fmt.Sprintf("%s: %s", v.Key, v.text()))
+	}
+	return strings.Join(all, "\n")
+}
+
+// highlight marks text out in red on a terminal
+func highlight(text string) string {
+	if !isTerminal(os.Stdout) {
+		return text
+	}
+	return "\x1b[31m" + text + "\x1b[0m"
+}
+
+// executionQuery selects executions of a Formula Instance. eventId, objectId and
+// status are sent to the platform; all of it is checked again on what comes back.
+// The platform lists executions newest first, so paging stops at the first page
+// reaching back before Since, or once Limit executions are selected.
+type executionQuery struct {
+	EventID  int
+	ObjectID int
+	Status   string
+	Since    time.Time
+	Until    time.Time
+	// Limit is how many of the newest executions are wanted, or 0 for all
+	Limit int
+}
+
+// executionStatuses are the statuses an execution can be selected by
+var executionStatuses = []string{executionSuccess, executionFailed, executionPending, "cancelled"}
+
+// params returns the parameters the platform filters on
+func (q executionQuery) params() url.Values {
+	v := url.Values{}
+	if q.EventID > 0 {
+		v.Set("eventId", strconv.Itoa(q.EventID))
+	}
+	if q.ObjectID > 0 {
+		v.Set("objectId", strconv.Itoa(q.ObjectID))
+	}
+	if q.Status != "" {
+		v.Set("status", q.Status)
+	}
+	return v
+}
+
+func (q executionQuery) match(e ce.FormulaInstanceExecution) bool {
+	if q.Status != "" && e.Status != q.Status {
+		return false
+	}
+	if !q.Since.IsZero() && e.CreateDate.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.CreateDate.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// executionsPageSize is how many executions are asked for at a time
+const executionsPageSize = 200
+
+// nextPageHeader carries the token for the page after the one returned
+const nextPageHeader = "Elements-Next-Page-Token"
+
+// listExecutions returns the executions of a Formula Instance that a query
+// selects, following the platform's pages until there are no more
+func listExecutions(instanceID string, q executionQuery, profilemap map[string]string) ([]ce.FormulaInstanceExecution, error) {
+	params := q.params()
+	params.Set("pageSize", strconv.Itoa(executionsPageSize))
+	var selected []ce.FormulaInstanceExecution
+	for {
+		uri := fmt.Sprintf("/formulas/instances/%s/executions?%s", instanceID, params.Encode())
+		bodybytes, status, header, curlcmd, err := platformRequestHeader("GET", uri, nil, profilemap)
+		if showCurl {
+			log.Println(curlcmd)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if status != 200 {
+			return nil, fmt.Errorf("unable to list executions of %s, %s", instanceID, platformError(status, bodybytes).Error())
+		}
+		var executions []ce.FormulaInstanceExecution
+		err = json.Unmarshal(bodybytes, &executions)
+		if err != nil {
+			return nil, fmt.Errorf("unable to understand executions of %s, %s", instanceID, err.Error())
+		}
+		for _, e := range executions {
+			if q.match(e) {
+				selected = append(selected, e)
+			}
+			if q.Limit > 0 && len(selected) == q.Limit {
+				return selected, nil
+			}
+		}
+		next := header.Get(nextPageHeader)
+		if next == "" || len(executions) == 0 {
+			return selected, nil
+		}
+		if !q.Since.IsZero() && executions[len(executions)-1].CreateDate.Before(q.Since) {
+			// the pages after this are older still
+			return selected, nil
+		}
+		params.Set("nextPage", next)
+	}
+}
+
+// parseTimeFlag reads a time given either relative to now, as a duration such
+// as 90m, 24h or 7d, or as a date (2006-01-02) or RFC 3339 time
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s isn't a duration such as 24h or 7d, or a date", value)
+}
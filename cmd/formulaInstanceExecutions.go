@@ -17,22 +17,26 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ghchinoy/ce-go/ce"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
 	outputLimit                   int
 	formulaExecutionQueryEventID  int
 	formulaExecutionQueryObjectID int
+	formulaExecutionQueryStatus   string
+	formulaExecutionQuerySince    string
+	formulaExecutionQueryUntil    string
+	formulaExecutionSort          string
+	formulaExecutionReverse       bool
 )
 
 // formulaInstanceExecutionsCmd represents the formula-instance-executions command
@@ -46,7 +50,11 @@ var formulaInstanceExecutionsCmd = &cobra.Command{
 var listFormulaInstanceExecutionsCmd = &cobra.Command{
 	Use:   "list <id>",
 	Short: "list executions for instance id",
-	Long:  `Lists the Formula Instance Executions given an ID of a Formula Instance`,
+	Long: `Lists the Formula Instance Executions given an ID of a Formula Instance.
+--event, --object and --status are sent to the platform to select executions;
+--since and --until select by creation time, given as a duration before now
+such as 24h or 7d, or as a date. Executions are listed newest first, or sorted
+by --sort created, updated, duration, status or id, and --top limits how many.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// check for profile
@@ -61,71 +69,45 @@ var listFormulaInstanceExecutionsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// add eventId and/or objectId query params as needed
-		// if formulaExecutionQueryObjectID | formulaExecutionQueryEventID > 0
-		var query []string
-
-		if formulaExecutionQueryEventID > 0 {
-			query = append(query, fmt.Sprintf("eventId=%v", formulaExecutionQueryEventID))
+		q, err := executionQueryFlags(formulaExecutionQuerySince, formulaExecutionQueryUntil)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
-		if formulaExecutionQueryObjectID > 0 {
-			query = append(query, fmt.Sprintf("objectId=%v", formulaExecutionQueryObjectID))
+		if (formulaExecutionSort == "" || formulaExecutionSort == "created") && !formulaExecutionReverse {
+			// the newest are all that will be shown
+			q.Limit = outputLimit
 		}
-		if len(query) > 0 {
-
+		executions, err := listExecutions(args[0], q, profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
-
-		bodybytes, status, curlcmd, err := ce.GetFormulaInstanceExecutions(profilemap["base"], profilemap["auth"], args[0])
-
-		if showCurl {
-			log.Println(curlcmd)
+		err = sortExecutions(executions, formulaExecutionSort, formulaExecutionReverse)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if outputLimit > 0 && len(executions) > outputLimit {
+			executions = executions[:outputLimit]
 		}
 
 		if outputJSON {
-			fmt.Printf("%s\n", bodybytes)
+			executionsbytes, _ := json.MarshalIndent(executions, "", "  ")
+			fmt.Printf("%s\n", executionsbytes)
 			return
 		}
 
-		if status != 200 {
-			fmt.Print(status)
-			if status == 404 {
-				fmt.Println("Unable to contact CE API")
-				return
-			}
-			fmt.Println()
-		}
-
 		data := [][]string{}
-
-		var executions []ce.FormulaInstanceExecution
-		err = json.Unmarshal(bodybytes, &executions)
-		if err != nil { // can't make an array of executions
-			log.Println(err.Error())
-		}
-		i := 0
-
 		for _, v := range executions {
-			diff := v.UpdatedDate.Sub(v.CreateDate)
-			var difftext string
-			if diff < 0 {
-				difftext = "pending"
-			} else {
-				difftext = fmt.Sprintf("%v s", diff.Seconds())
-			}
 			data = append(data, []string{
 				strconv.Itoa(v.ID),
 				strconv.Itoa(v.FormulaInstanceID),
 				v.Status,
 				v.CreateDate.String(),
 				v.UpdatedDate.String(),
-				difftext,
+				executionDuration(v.Status, v.CreateDate, v.UpdatedDate),
 			})
-			i++
-			if outputLimit > 0 {
-				if i > outputLimit {
-					break
-				}
-			}
 		}
 
 		table := tablewriter.NewWriter(os.Stdout)
@@ -137,119 +119,110 @@ var listFormulaInstanceExecutionsCmd = &cobra.Command{
 	},
 }
 
-// cancelExecutionCmd represents the cancelExecution command
-var cancelExecutionCmd = &cobra.Command{
-	Use:   "cancel <id>",
-	Short: "Cancel a Formula Instance Execution by ID",
-	Long:  `Given an Execution ID, cancel the Formula Instance Execution`,
-	Run: func(cmd *cobra.Command, args []string) {
-
-		// check for profile
-		profilemap, err := getAuth(profile)
+// executionQueryFlags builds an executionQuery from the execution selection flags
+// and the given --since and --until values
+func executionQueryFlags(since, until string) (executionQuery, error) {
+	q := executionQuery{
+		EventID:  formulaExecutionQueryEventID,
+		ObjectID: formulaExecutionQueryObjectID,
+		Status:   formulaExecutionQueryStatus,
+	}
+	if q.Status != "" && !containsString(executionStatuses, q.Status) {
+		return q, fmt.Errorf("--status must be one of %s", strings.Join(executionStatuses, ", "))
+	}
+	now := time.Now()
+	var err error
+	if since != "" {
+		q.Since, err = parseTimeFlag(since, now)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			return q, err
 		}
-
-		if len(args) < 1 {
-			fmt.Println("must supply an ID of a Formula")
-			os.Exit(1)
+	}
+	if until != "" {
+		q.Until, err = parseTimeFlag(until, now)
+		if err != nil {
+			return q, err
 		}
+	}
+	return q, nil
+}
 
-		bodybytes, status, curlcmd, err := ce.CancelFormulaExecution(
-			profilemap["base"],
-			profilemap["auth"],
-			args[0],
-		)
-
-		if showCurl {
-			log.Println(curlcmd)
+// sortExecutions orders executions by a field, newest or longest first unless reversed
+func sortExecutions(executions []ce.FormulaInstanceExecution, by string, reverse bool) error {
+	var less func(a, b ce.FormulaInstanceExecution) bool
+	switch by {
+	case "", "created":
+		less = func(a, b ce.FormulaInstanceExecution) bool { return a.CreateDate.After(b.CreateDate) }
+	case "updated":
+		less = func(a, b ce.FormulaInstanceExecution) bool { return a.UpdatedDate.After(b.UpdatedDate) }
+	case "duration":
+		less = func(a, b ce.FormulaInstanceExecution) bool {
+			return a.UpdatedDate.Sub(a.CreateDate) > b.UpdatedDate.Sub(b.CreateDate)
 		}
-
-		if outputJSON {
-			fmt.Printf("%s\n", bodybytes)
-			return
+	case "status":
+		less = func(a, b ce.FormulaInstanceExecution) bool { return a.Status < b.Status }
+	case "id":
+		less = func(a, b ce.FormulaInstanceExecution) bool { return a.ID > b.ID }
+	default:
+		return fmt.Errorf("--sort must be created, updated, duration, status or id")
+	}
+	sort.SliceStable(executions, func(i, j int) bool {
+		if reverse {
+			return less(executions[j], executions[i])
 		}
+		return less(executions[i], executions[j])
+	})
+	return nil
+}
 
-		if status != 200 {
-			fmt.Println(status)
-			var ficr ce.FormulaInstanceCreationResponse
-			err = json.Unmarshal(bodybytes, &ficr)
-			if err != nil {
-				fmt.Println("Cannot process response, tried error message")
-				os.Exit(1)
-			}
-			fmt.Println(ficr.Message)
-			os.Exit(1)
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
-		fmt.Println(status)
+	}
+	return false
+}
 
+// cancelExecutionCmd represents the cancelExecution command
+var cancelExecutionCmd = &cobra.Command{
+	Use:   "cancel [id...]",
+	Short: "Cancel Formula Instance Executions by ID or selection",
+	Long: `Given Execution IDs, cancel the Formula Instance Executions.
+Instead of IDs, executions can be selected with --instance, --formula, --status
+(default pending), --since and --older-than, across every Instance if neither
+--instance nor --formula is given. The selected executions are listed and, unless
+--yes is given, confirmed before they're cancelled --concurrency at a time.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runExecutionAction(cmd, args, cancelAction)
 	},
 }
 
 // retryFormulaInstanceExecutionCmd represents the retryFormulaInstanceExecution command
 var retryFormulaInstanceExecutionCmd = &cobra.Command{
-	Use:   "retry",
-	Short: "retry an execution",
-	Long:  `Retry a Formula Instance Execution that has previously run`,
+	Use:   "retry [id...]",
+	Short: "retry executions by ID or selection",
+	Long: `Retry Formula Instance Executions that have previously run, given their IDs.
+Instead of IDs, executions can be selected with --instance, --formula, --status
+(default failed), --since and --older-than, across every Instance if neither
+--instance nor --formula is given. The selected executions are listed and, unless
+--yes is given, confirmed before they're retried --concurrency at a time.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if !viper.IsSet(profile + ".base") {
-			fmt.Println("Can't find info for profile", profile)
-			os.Exit(1)
-		}
-
-		if len(args) < 1 {
-			fmt.Println("must supply an ID of a Formula Instance Execution")
-			os.Exit(1)
-		}
-
-		base := viper.Get(profile + ".base")
-		user := viper.Get(profile + ".user")
-		org := viper.Get(profile + ".org")
-
-		url := fmt.Sprintf("%s%s",
-			base,
-			fmt.Sprintf(ce.FormulaRetryExecutionURI, args[0]),
-		)
-		auth := fmt.Sprintf("User %s, Organization %s", user, org)
-
-		client := &http.Client{}
-		req, err := http.NewRequest("PUT", url, nil)
-		if err != nil {
-			fmt.Println("Can't construct request", err.Error())
-			os.Exit(1)
-		}
-		req.Header.Add("Authorization", auth)
-		req.Header.Add("Accept", "application/json")
-		resp, err := client.Do(req)
-		if err != nil {
-			fmt.Println("Cannot process response", err.Error())
-			os.Exit(1)
-		}
-		bodybytes, err := ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
-
-		if outputJSON {
-			fmt.Printf("%s\n", bodybytes)
-			return
-		}
-
-		if resp.StatusCode != 200 {
-			var ex ce.FormulaInstanceCreationResponse
-			err = json.Unmarshal(bodybytes, &ex)
-			fmt.Printf("%s\nID: %v (%s)\n", ex.Message, args[0], ex.RequestID)
-			fmt.Println(resp.Status)
-			return
-		}
-
+		runExecutionAction(cmd, args, retryAction)
 	},
 }
 
+var executionDetailStep string
+
 // detailExecutionIDCmd results in the specific execution details
 var detailExecutionIDCmd = &cobra.Command{
 	Use:   "details <id>",
 	Short: "Show details of a Formula Instance Execution by ID",
-	Long:  `Given an Execution ID, show details of each of the steps in a the Formula Instance Execution`,
+	Long: `Given an Execution ID, show details of each of the steps in a the Formula Instance Execution:
+the trigger event, then each step in the order it ran with its status, start and end
+times and duration, and the error of a step that failed.
+--step <name> shows the values a step recorded, such as its request and response,
+for each time it ran.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// check for profile
@@ -272,30 +245,104 @@ var detailExecutionIDCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		bodybytes, statuscode, curlcmd, err := ce.GetFormulaInstanceExecutionID(args[0], profilemap["base"], profilemap["auth"])
+		ex, bodybytes, err := fetchExecution(args[0], profilemap["base"], profilemap["auth"])
 		if err != nil {
-			fmt.Println("Unable to get formula execution id", err.Error())
+			fmt.Println(err.Error())
 			os.Exit(1)
 		}
 
-		// handle global options, curl
-		if showCurl {
-			log.Println(curlcmd)
-		}
-		// handle non 200
-		if statuscode != 200 {
-			log.Printf("HTTP Error: %v\n", statuscode)
-			// handle this nicely, show error description
+		if executionDetailStep != "" {
+			printStepValues(ex, executionDetailStep)
+			return
 		}
+
 		// handle global options, json
 		if outputJSON {
 			fmt.Printf("%s\n", bodybytes)
 			return
 		}
-
+		printExecutionDetails(ex)
 	},
 }
 
+// printExecutionDetails prints the trigger and steps of an execution, and the
+// errors of the steps that failed
+func printExecutionDetails(ex *formulaExecution) {
+	fmt.Printf("Execution %v of Formula Instance %v: %s\n", ex.ID, ex.FormulaInstanceID, ex.Status)
+	fmt.Printf("Started:  %s\n", ex.CreatedDate)
+	fmt.Printf("Updated:  %s\n", ex.UpdatedDate)
+	fmt.Printf("Duration: %s\n", executionDuration(ex.Status, ex.CreatedDate, ex.UpdatedDate))
+
+	var failed []stepExecution
+	data := [][]string{}
+	n := 0
+	for _, s := range ex.StepExecutions {
+		if s.StepName == triggerStep {
+			fmt.Println("\nTrigger:")
+			for _, v := range s.StepExecutionValues {
+				fmt.Printf("%s: %s\n", v.Key, v.text())
+			}
+			continue
+		}
+		n++
+		status := s.Status
+		if status == executionFailed {
+			status = highlight(status)
+			failed = append(failed, s)
+		}
+		data = append(data, []string{
+			strconv.Itoa(n),
+			s.StepName,
+			status,
+			s.CreatedDate.Format(time.RFC3339),
+			s.UpdatedDate.Format(time.RFC3339),
+			executionDuration(s.Status, s.CreatedDate, s.UpdatedDate),
+		})
+	}
+
+	fmt.Println()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"#", "Step", "Status", "Started", "Ended", "Duration"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+
+	for _, s := range failed {
+		fmt.Printf("\n%s\n%s\n", highlight(fmt.Sprintf("Step %s failed:", s.StepName)), s.stepError())
+	}
+}
+
+// printStepValues prints the values recorded each time a step ran
+func printStepValues(ex *formulaExecution, name string) {
+	var runs []stepExecution
+	for _, s := range ex.StepExecutions {
+		if s.StepName == name {
+			runs = append(runs, s)
+		}
+	}
+	if len(runs) == 0 {
+		fmt.Printf("Step %s didn't run in execution %v\n", name, ex.ID)
+		os.Exit(1)
+	}
+
+	if outputJSON {
+		runsbytes, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Printf("%s\n", runsbytes)
+		return
+	}
+	for i, s := range runs {
+		if len(runs) > 1 {
+			fmt.Printf("# %s run %v of %v, %s\n", name, i+1, len(runs), s.Status)
+		} else {
+			fmt.Printf("# %s, %s\n", name, s.Status)
+		}
+		for _, v := range s.StepExecutionValues {
+			fmt.Printf("%s:\n%s\n", v.Key, v.text())
+		}
+		fmt.Println()
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(formulaInstanceExecutionsCmd)
 	formulaInstanceExecutionsCmd.PersistentFlags().StringVar(&profile, "profile", "default", "profile name")
@@ -305,10 +352,16 @@ func init() {
 	listFormulaInstanceExecutionsCmd.Flags().IntVarP(&outputLimit, "top", "t", 0, "output limit from latest")
 	listFormulaInstanceExecutionsCmd.Flags().IntVarP(&formulaExecutionQueryEventID, "event", "e", 0, "event ID to search for")
 	listFormulaInstanceExecutionsCmd.Flags().IntVarP(&formulaExecutionQueryObjectID, "object", "o", 0, "object ID to search for")
+	listFormulaInstanceExecutionsCmd.Flags().StringVarP(&formulaExecutionQueryStatus, "status", "s", "", "success, failed, pending or cancelled")
+	listFormulaInstanceExecutionsCmd.Flags().StringVar(&formulaExecutionQuerySince, "since", "", "created since, as a duration before now (24h, 7d) or a date")
+	listFormulaInstanceExecutionsCmd.Flags().StringVar(&formulaExecutionQueryUntil, "until", "", "created until, as a duration before now (1h, 2d) or a date")
+	listFormulaInstanceExecutionsCmd.Flags().StringVar(&formulaExecutionSort, "sort", "created", "sort by created, updated, duration, status or id")
+	listFormulaInstanceExecutionsCmd.Flags().BoolVar(&formulaExecutionReverse, "reverse", false, "reverse the sort order")
 
 	formulaInstanceExecutionsCmd.AddCommand(cancelExecutionCmd)
 
 	formulaInstanceExecutionsCmd.AddCommand(retryFormulaInstanceExecutionCmd)
 
 	formulaInstanceExecutionsCmd.AddCommand(detailExecutionIDCmd)
+	detailExecutionIDCmd.Flags().StringVar(&executionDetailStep, "step", "", "show the values recorded by the step of this name")
 }
@@ -0,0 +1,62 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+)
+
+// formulaSourceBytes returns the Formula JSON for an argument that's either a
+// path to a Formula JSON file, a directory written by formulas unpack, or the
+// ID of a Formula on the platform
+func formulaSourceBytes(arg string) ([]byte, error) {
+	if info, err := os.Stat(arg); err == nil {
+		if info.IsDir() {
+			return formula.Pack(arg)
+		}
+		return ioutil.ReadFile(arg)
+	}
+	if _, err := strconv.Atoi(arg); err != nil {
+		return nil, fmt.Errorf("%s is neither a Formula JSON file nor a Formula ID", arg)
+	}
+
+	profilemap, err := getAuth(profile)
+	if err != nil {
+		return nil, err
+	}
+	return pullFormula(arg, profilemap)
+}
+
+// pullFormula retrieves a Formula's JSON
+func pullFormula(id string, profilemap map[string]string) ([]byte, error) {
+	bodybytes, statuscode, curlcmd, err := ce.FormulaDetailsAsBytes(id, profilemap["base"], profilemap["auth"])
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if statuscode != 200 {
+		return nil, fmt.Errorf("unable to retrieve formula %s, HTTP %v: %s", id, statuscode, bodybytes)
+	}
+	return bodybytes, nil
+}
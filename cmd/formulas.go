@@ -21,10 +21,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"strconv"
 
 	"github.com/ghchinoy/ce-go/ce"
-	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -116,248 +114,6 @@ var importFormulaCmd = &cobra.Command{
 	},
 }
 
-// formulaDeactivateCmd represents the formulaDeactivate command
-var formulaDeactivateCmd = &cobra.Command{
-	Use:   "deactivate",
-	Short: "Deactivate a Formula template",
-	Long:  `Sets a Formula template to an inactive state`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// check for profile
-		profilemap, err := getAuth(profile)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		if len(args) < 1 {
-			fmt.Println("must supply an ID of a Formula")
-			os.Exit(1)
-		}
-
-		// Get the Formula
-		formulaResponseBytes, statuscode, curlcmd, err := ce.FormulaDetailsAsBytes(args[0], profilemap["base"], profilemap["auth"])
-		if statuscode != 200 {
-			fmt.Printf("Unable to retrieve formula %s, %s\n", args[0], err.Error())
-			os.Exit(1)
-		}
-		var formula ce.Formula
-		err = json.Unmarshal(formulaResponseBytes, &formula)
-		if err != nil {
-			fmt.Println("Unable to understand formula response", err.Error())
-			os.Exit(1)
-		}
-
-		// Change the Formula to Active
-		formula.Active = false
-
-		// PATCH to set the Formula back
-		patchBytes, statuscode, err := ce.FormulaUpdate(args[0], profilemap["base"], profilemap["auth"], formula)
-		err = json.Unmarshal(patchBytes, &formula)
-		if err != nil {
-			fmt.Printf("Unable to retrieve formula, %s\n", err.Error())
-			os.Exit(1)
-		}
-
-		if showCurl {
-			log.Println(curlcmd)
-		}
-
-		if outputJSON {
-			fmt.Printf("%s\n", patchBytes)
-			return
-		}
-
-		if statuscode != 200 {
-			fmt.Println(statuscode)
-			var ficr ce.FormulaInstanceCreationResponse
-			err = json.Unmarshal(patchBytes, &ficr)
-			if err != nil {
-				fmt.Println("Cannot process response, tried error message")
-				os.Exit(1)
-			}
-			fmt.Println(ficr.Message)
-			os.Exit(1)
-		}
-
-		var f ce.Formula
-		err = json.Unmarshal(patchBytes, &f)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-
-		formulaResponseBytes, statuscode, curlcmd, err = ce.FormulaDetailsAsBytes(strconv.Itoa(f.ID), profilemap["base"], profilemap["auth"])
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-		if statuscode != 200 {
-			fmt.Printf("Unable to retrieve updated formula %s, %s\n", args[0], err.Error())
-			os.Exit(1)
-		}
-		err = json.Unmarshal(formulaResponseBytes, &f)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-		if showCurl {
-			log.Println(curlcmd)
-		}
-
-		var instancecount string
-		instances, err := ce.GetInstancesOfFormula(f.ID, profilemap["base"], profilemap["auth"])
-		if err != nil {
-			// unable to retrieve instances of formula!
-			instancecount = "N/A"
-		}
-		instancecount = strconv.Itoa(len(instances))
-
-		api := "N/A"
-		if f.Triggers[0].Type == "manual" {
-			api = f.API
-		}
-
-		data := [][]string{}
-		data = append(data, []string{
-			strconv.Itoa(f.ID),
-			f.Name,
-			strconv.FormatBool(f.Active),
-			strconv.Itoa(len(f.Steps)),
-			f.Triggers[0].Type,
-			instancecount,
-			api,
-		})
-
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"ID", "Name", "active", "steps", "trigger", "instances", "api"})
-		table.SetBorder(false)
-		table.AppendBulk(data)
-		table.Render()
-	},
-}
-
-// formulaActivateCmd represents the formulaActivate command
-var formulaActivateCmd = &cobra.Command{
-	Use:   "activate",
-	Short: "Activate a Formula template",
-	Long:  `Sets a Formula template to active state`,
-	Run: func(cmd *cobra.Command, args []string) {
-		// check for profile
-		profilemap, err := getAuth(profile)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		if len(args) < 1 {
-			fmt.Println("must supply an ID of a Formula")
-			os.Exit(1)
-		}
-
-		base := profilemap["base"]
-		auth := profilemap["auth"]
-
-		// Get the Formula
-		formulaResponseBytes, statuscode, curlcmd, err := ce.FormulaDetailsAsBytes(args[0], base, auth)
-		if statuscode != 200 {
-			fmt.Printf("Unable to retrieve formula %s, %s\n", args[0], err.Error())
-			os.Exit(1)
-		}
-		var formula ce.Formula
-		err = json.Unmarshal(formulaResponseBytes, &formula)
-		if err != nil {
-			fmt.Println("Unable to understand formula response", err.Error())
-			os.Exit(1)
-		}
-
-		// Change the Formula to Active
-		formula.Active = true
-
-		// PATCH to set the Formula back
-		patchBytes, statuscode, err := ce.FormulaUpdate(args[0], base, auth, formula)
-		err = json.Unmarshal(patchBytes, &formula)
-		if err != nil {
-			fmt.Printf("Unable to retrieve formula, %s\n", err.Error())
-			os.Exit(1)
-		}
-
-		if showCurl {
-			log.Println(curlcmd)
-		}
-
-		if outputJSON {
-			fmt.Printf("%s\n", patchBytes)
-			return
-		}
-
-		if statuscode != 200 {
-			fmt.Println(statuscode)
-			var ficr ce.FormulaInstanceCreationResponse
-			err = json.Unmarshal(patchBytes, &ficr)
-			if err != nil {
-				fmt.Println("Cannot process response, tried error message")
-				os.Exit(1)
-			}
-			fmt.Println(ficr.Message)
-			os.Exit(1)
-		}
-
-		var f ce.Formula
-		err = json.Unmarshal(patchBytes, &f)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-
-		formulaResponseBytes, statuscode, curlcmd, err = ce.FormulaDetailsAsBytes(strconv.Itoa(f.ID), base, auth)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-		if statuscode != 200 {
-			fmt.Printf("Unable to retrieve updated formula %s, %s\n", args[0], err.Error())
-			os.Exit(1)
-		}
-		err = json.Unmarshal(formulaResponseBytes, &f)
-		if err != nil {
-			fmt.Println(err.Error())
-			os.Exit(1)
-		}
-		if showCurl {
-			log.Println(curlcmd)
-		}
-		var instancecount string
-		instances, err := ce.GetInstancesOfFormula(f.ID, base, auth)
-		if err != nil {
-			// unable to retrieve instances of formula!
-			instancecount = "N/A"
-		}
-		instancecount = strconv.Itoa(len(instances))
-
-		api := "N/A"
-		if f.Triggers[0].Type == "manual" {
-			api = f.API
-		}
-
-		data := [][]string{}
-		data = append(data, []string{
-			strconv.Itoa(f.ID),
-			f.Name,
-			strconv.FormatBool(f.Active),
-			strconv.Itoa(len(f.Steps)),
-			f.Triggers[0].Type,
-			instancecount,
-			api,
-		})
-
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"ID", "Name", "active", "steps", "trigger", "instances", "api"})
-		table.SetBorder(false)
-		table.AppendBulk(data)
-		table.Render()
-	},
-}
-
 // deleteFormulaCmd represents the deleteFormula command
 var deleteFormulaCmd = &cobra.Command{
 	Use:   "delete <id>",
@@ -0,0 +1,96 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+// graphFormulaCmd draws a Formula's triggers and steps
+var graphFormulaCmd = &cobra.Command{
+	Use:   "graph <id|file>",
+	Short: "Draw a Formula as a graph",
+	Long: `Renders a Formula, by ID or from a Formula JSON file, as a graph of its
+trigger and steps, with onSuccess edges solid and onFailure edges dashed.
+Nodes are labeled with the step type and any Element Instance references.
+--format dot prints Graphviz DOT, --format mermaid a Mermaid flowchart suitable
+for pull requests and docs, and --format svg an SVG image, which needs
+Graphviz's dot on the PATH.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a Formula ID or a path to a Formula JSON file")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		data, err := formulaSourceBytes(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		f, err := formula.Parse(data)
+		if err != nil {
+			fmt.Println("doesn't seem like a Formula", err.Error())
+			os.Exit(1)
+		}
+
+		switch graphFormat {
+		case formula.GraphDOT:
+			fmt.Print(formula.DOT(f))
+		case formula.GraphMermaid:
+			fmt.Print(formula.Mermaid(f))
+		case "svg":
+			svg, err := renderDOT(formula.DOT(f), "svg")
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("%s", svg)
+		default:
+			fmt.Printf("unknown format %s, must be one of dot, mermaid, svg\n", graphFormat)
+			os.Exit(1)
+		}
+	},
+}
+
+// renderDOT renders a DOT graph with Graphviz
+func renderDOT(dot, format string) ([]byte, error) {
+	path, err := exec.LookPath("dot")
+	if err != nil {
+		return nil, fmt.Errorf("rendering %s needs Graphviz's dot command, use --format dot or mermaid instead", format)
+	}
+	var stdout, stderr bytes.Buffer
+	render := exec.Command(path, "-T"+format)
+	render.Stdin = bytes.NewBufferString(dot)
+	render.Stdout = &stdout
+	render.Stderr = &stderr
+	if err := render.Run(); err != nil {
+		return nil, fmt.Errorf("dot failed: %s %s", err.Error(), stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func init() {
+	formulasCmd.AddCommand(graphFormulaCmd)
+	graphFormulaCmd.Flags().StringVarP(&graphFormat, "format", "f", formula.GraphDOT, "graph format: dot, mermaid or svg")
+}
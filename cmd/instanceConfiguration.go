@@ -0,0 +1,154 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+)
+
+// readConfigurationArg reads Formula Instance configuration given either as
+// a JSON file name or as JSON
+func readConfigurationArg(arg string) (map[string]interface{}, error) {
+	data := []byte(arg)
+	if _, err := os.Stat(arg); err == nil {
+		data, err = ioutil.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var values map[string]interface{}
+	err := decodeJSON(data, &values)
+	if err != nil {
+		return nil, fmt.Errorf("configuration must be a JSON object or a file of one: %s", err.Error())
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+	}
+	return values, nil
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// accountElementInstances returns the Element Instances of the account by ID
+func accountElementInstances(base, auth string) (map[int]ce.ElementInstance, error) {
+	bodybytes, status, curlcmd, err := ce.GetAllInstances(base, auth)
+	if err != nil {
+		return nil, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("unable to list Element Instances, HTTP %v", status)
+	}
+	var instances []ce.ElementInstance
+	err = json.Unmarshal(bodybytes, &instances)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]ce.ElementInstance)
+	for _, i := range instances {
+		byID[i.ID] = i
+	}
+	return byID, nil
+}
+
+// promptLine asks a question on the terminal and returns the answer
+func promptLine(in *bufio.Reader, question string) (string, error) {
+	return promptLineTo(os.Stdout, in, question)
+}
+
+// promptLineTo is promptLine asking the question on w
+func promptLineTo(w io.Writer, in *bufio.Reader, question string) (string, error) {
+	fmt.Fprintf(w, "%s: ", question)
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// promptConfiguration asks for each configuration value a Formula declares,
+// listing the account's Element Instances for those that need one
+func promptConfiguration(in *bufio.Reader, f *formula.Formula, instances map[int]ce.ElementInstance) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	listed := false
+	for _, c := range f.Configuration {
+		if c.Type == formula.ConfigElementInstance && !listed {
+			printElementInstanceChoices(instances)
+			listed = true
+		}
+		answer, err := promptLine(in, formula.DescribeConfiguration(c))
+		if err != nil {
+			return nil, err
+		}
+		if answer != "" {
+			values[c.Key] = answer
+		}
+	}
+	return values, nil
+}
+
+func printElementInstanceChoices(instances map[int]ce.ElementInstance) {
+	var ids []int
+	for id := range instances {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fmt.Println("Element Instances:")
+	for _, id := range ids {
+		fmt.Printf("%9v  %-20s %s\n", id, instances[id].Element.Key, instances[id].Name)
+	}
+}
+
+// checkInstanceConfiguration validates configuration against a Formula, looking up
+// Element Instances in the account
+func checkInstanceConfiguration(f *formula.Formula, values map[string]interface{}, instances map[int]ce.ElementInstance) []string {
+	return formula.ValidateConfiguration(f, values, func(id int) bool {
+		_, ok := instances[id]
+		return ok
+	})
+}
+
+// printConfigurationProblems reports configuration problems all together
+func printConfigurationProblems(problems []string) {
+	fmt.Printf("Formula Instance configuration has %v problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Printf("  %s\n", p)
+	}
+}
+
+// formulaDeclaresElementInstances reports whether a Formula's configuration needs Element Instances
+func formulaDeclaresElementInstances(f *formula.Formula) bool {
+	return len(f.ElementInstanceConfigKeys()) > 0
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+// lintFormulasCmd checks Formula JSON files offline
+var lintFormulasCmd = &cobra.Command{
+	Use:   "lint <file|dir>",
+	Short: "Check Formula JSON files for problems",
+	Long: `Checks Formula JSON files offline, without a profile, for:
+onSuccess/onFailure references to missing steps, unreachable and duplicate steps,
+triggers missing their configuration, ${config.x} references that aren't declared
+in the Formula configuration, and script steps with JavaScript syntax errors.
+Given a directory, every JSON file in it that looks like a Formula is checked.
+Diagnostics are printed as file:step:message, and the exit code is non-zero if
+there are any, so this can be used as a pre-commit hook.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a path to a Formula JSON file or a directory")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		var files []string
+		for _, arg := range args {
+			found, err := formulaFiles(arg)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			files = append(files, found...)
+		}
+
+		var diags []formula.Diagnostic
+		for _, file := range files {
+			f, err := formula.Load(file)
+			if err != nil {
+				diags = append(diags, formula.Diagnostic{File: file, Step: "formula", Message: fmt.Sprintf("doesn't seem like a Formula: %s", err.Error())})
+				continue
+			}
+			diags = append(diags, formula.Lint(file, f)...)
+		}
+
+		for _, d := range diags {
+			fmt.Println(d.String())
+		}
+		if len(diags) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// formulaFiles returns path if it's a file, or the Formula JSON files within it if it's a directory
+func formulaFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	var files []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if formula.LooksLikeFormula(data) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func init() {
+	formulasCmd.AddCommand(lintFormulasCmd)
+}
@@ -0,0 +1,537 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	instanceListFormula string
+	instanceListActive  string
+	instanceListName    string
+
+	instanceUpdateConfiguration string
+	instanceUpdateReplace       bool
+)
+
+// listAllFormulaInstancesCmd lists the Formula Instances of every Formula
+var listAllFormulaInstancesCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the Instances of all Formulas",
+	Long: `Lists the Formula Instances of every Formula in the account.
+--formula selects the Formula by ID, or by name as a glob or /regex/;
+--active true or false selects by state; --name selects Instances whose
+name matches a glob or /regex/.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		var active *bool
+		if instanceListActive != "" {
+			a, err := strconv.ParseBool(instanceListActive)
+			if err != nil {
+				fmt.Println("--active must be true or false")
+				os.Exit(1)
+			}
+			active = &a
+		}
+		var name namePattern
+		if instanceListName != "" {
+			name, err = parseNamePattern(instanceListName)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		formulas, err := listFormulas(profilemap["base"], profilemap["auth"])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if instanceListFormula != "" {
+			formulas, err = selectFormulas(formulas, instanceListFormula)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		all, err := accountFormulaInstances(profilemap["base"], profilemap["auth"], formulas)
+		if err != nil {
+			// list what could be retrieved, then fail
+			log.Println(err.Error())
+		}
+		var instances []ce.FormulaInstance
+		for _, i := range all {
+			if active != nil && i.Active != *active {
+				continue
+			}
+			if instanceListName != "" && !name.match(i.Name) {
+				continue
+			}
+			instances = append(instances, i)
+		}
+
+		if outputJSON {
+			instancesbytes, _ := json.MarshalIndent(instances, "", "  ")
+			fmt.Printf("%s\n", instancesbytes)
+		} else {
+			data := [][]string{}
+			for _, i := range instances {
+				data = append(data, []string{
+					strconv.Itoa(i.ID),
+					i.Name,
+					strconv.FormatBool(i.Active),
+					fmt.Sprintf("%v %s", i.Formula.ID, i.Formula.Name),
+					i.CreatedDate.String(),
+				})
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"ID", "Instance", "active", "Formula", "Created"})
+			table.SetBorder(false)
+			table.AppendBulk(data)
+			table.Render()
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+// resolvedConfiguration is a configuration value of a Formula Instance with what it refers to
+type resolvedConfiguration struct {
+	Key      string      `json:"key"`
+	Name     string      `json:"name,omitempty"`
+	Type     string      `json:"type,omitempty"`
+	Required bool        `json:"required,omitempty"`
+	Value    interface{} `json:"value"`
+	Resolved string      `json:"resolved,omitempty"`
+}
+
+// showFormulaInstanceCmd shows a Formula Instance and its configuration
+var showFormulaInstanceCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "show a Formula Instance and its configuration",
+	Long: `Shows a Formula Instance with its configuration resolved against the
+Formula: each declared value with its name and type, the Element and name
+of Element Instances, required values that are missing and values the
+Formula doesn't declare.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply an ID of a Formula Instance")
+			os.Exit(1)
+		}
+
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		instance, f, err := formulaInstanceAndFormula(args[0], profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		var elementInstances map[int]ce.ElementInstance
+		if formulaDeclaresElementInstances(f) {
+			elementInstances, err = accountElementInstances(profilemap["base"], profilemap["auth"])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		resolved := resolveConfiguration(f, instanceConfiguration(instance), elementInstances)
+
+		if outputJSON {
+			showbytes, _ := json.MarshalIndent(struct {
+				ce.FormulaInstance
+				Configuration []resolvedConfiguration `json:"configuration"`
+			}{instance, resolved}, "", "  ")
+			fmt.Printf("%s\n", showbytes)
+			return
+		}
+
+		fmt.Printf("Formula Instance %v %s\n", instance.ID, instance.Name)
+		fmt.Printf("Formula:  %v %s\n", f.ID, f.Name)
+		fmt.Printf("Active:   %v\n", instance.Active)
+		fmt.Printf("Created:  %s\n", instance.CreatedDate)
+		if len(resolved) == 0 {
+			return
+		}
+		fmt.Println()
+		data := [][]string{}
+		for _, r := range resolved {
+			value := ""
+			if r.Value != nil {
+				value = fmt.Sprintf("%v", r.Value)
+			}
+			data = append(data, []string{r.Key, r.Type, value, r.Resolved})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Key", "Type", "Value", "Resolved"})
+		table.SetBorder(false)
+		table.AppendBulk(data)
+		table.Render()
+	},
+}
+
+// updateFormulaInstanceCmd updates the configuration of a Formula Instance
+var updateFormulaInstanceCmd = &cobra.Command{
+	Use:   "update <id>",
+	Short: "update the configuration of a Formula Instance",
+	Long: `Updates the configuration of a Formula Instance, given as JSON or a JSON
+file with --configuration. The values given replace those of the Instance and
+the others are kept, unless --replace is used. The resulting configuration is
+checked against the Formula, as it is by create.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 || instanceUpdateConfiguration == "" {
+			fmt.Println("must supply an ID of a Formula Instance and --configuration")
+			os.Exit(1)
+		}
+
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		values, err := readConfigurationArg(instanceUpdateConfiguration)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		instance, f, err := formulaInstanceAndFormula(args[0], profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if !instanceUpdateReplace {
+			merged := instanceConfiguration(instance)
+			for k, v := range values {
+				merged[k] = v
+			}
+			values = merged
+		}
+
+		var elementInstances map[int]ce.ElementInstance
+		if formulaDeclaresElementInstances(f) {
+			elementInstances, err = accountElementInstances(profilemap["base"], profilemap["auth"])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		problems := checkInstanceConfiguration(f, values, elementInstances)
+		if len(problems) > 0 {
+			printConfigurationProblems(problems)
+			os.Exit(1)
+		}
+
+		config := ce.FormulaInstanceConfig{Name: instance.Name, Active: instance.Active, Configuration: values}
+		bodybytes, status, curlcmd, err := platformRequest("PUT", formulaInstanceURI(instance), config, profilemap)
+		if showCurl {
+			log.Println(curlcmd)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if outputJSON {
+			fmt.Printf("%s\n", bodybytes)
+		}
+		if status != 200 {
+			fmt.Println(platformError(status, bodybytes).Error())
+			os.Exit(1)
+		}
+		if !outputJSON {
+			fmt.Printf("Formula Instance %v updated.\n", instance.ID)
+		}
+	},
+}
+
+// enableFormulaInstanceCmd activates Formula Instances
+var enableFormulaInstanceCmd = &cobra.Command{
+	Use:   "enable <id>...",
+	Short: "activate Formula Instances",
+	Long:  `Activates one or more Formula Instances by ID, so that they are triggered`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setFormulaInstancesActive(args, true)
+	},
+}
+
+// disableFormulaInstanceCmd deactivates Formula Instances
+var disableFormulaInstanceCmd = &cobra.Command{
+	Use:   "disable <id>...",
+	Short: "deactivate Formula Instances",
+	Long:  `Deactivates one or more Formula Instances by ID, so that they are no longer triggered`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setFormulaInstancesActive(args, false)
+	},
+}
+
+func setFormulaInstancesActive(args []string, active bool) {
+	if len(args) < 1 {
+		fmt.Println("must supply one or more IDs of Formula Instances")
+		os.Exit(1)
+	}
+	var ids []int
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Printf("%s isn't a Formula Instance ID\n", arg)
+			os.Exit(1)
+		}
+		ids = append(ids, id)
+	}
+
+	// check for profile
+	profilemap, err := getAuth(profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	state := "activated"
+	if !active {
+		state = "deactivated"
+	}
+	failed := 0
+	for _, id := range ids {
+		instance, err := getFormulaInstance(id, profilemap)
+		if err != nil {
+			fmt.Printf("%v: %s\n", id, err.Error())
+			failed++
+			continue
+		}
+		if instance.Active == active {
+			fmt.Printf("%v %s: already %s\n", id, instance.Name, state)
+			continue
+		}
+		err = setFormulaInstanceActive(instance, active, profilemap)
+		if err != nil {
+			fmt.Printf("%v %s: %s\n", id, instance.Name, err.Error())
+			failed++
+			continue
+		}
+		fmt.Printf("%v %s: %s\n", id, instance.Name, state)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// setFormulaInstanceActive activates or deactivates a Formula Instance
+func setFormulaInstanceActive(instance ce.FormulaInstance, active bool, profilemap map[string]string) error {
+	method := "PUT"
+	if !active {
+		method = "DELETE"
+	}
+	bodybytes, status, curlcmd, err := platformRequest(method, formulaInstanceURI(instance)+"/active", nil, profilemap)
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if err != nil {
+		return err
+	}
+	if status != 200 {
+		return platformError(status, bodybytes)
+	}
+	return nil
+}
+
+// selectFormulas returns the Formulas whose ID is given, or whose name matches a glob or /regex/
+func selectFormulas(formulas []ce.Formula, selector string) ([]ce.Formula, error) {
+	if id, err := strconv.Atoi(selector); err == nil {
+		for _, f := range formulas {
+			if f.ID == id {
+				return []ce.Formula{f}, nil
+			}
+		}
+		return nil, fmt.Errorf("no Formula %v", id)
+	}
+	pattern, err := parseNamePattern(selector)
+	if err != nil {
+		return nil, err
+	}
+	var selected []ce.Formula
+	for _, f := range formulas {
+		if pattern.match(f.Name) {
+			selected = append(selected, f)
+		}
+	}
+	return selected, nil
+}
+
+// accountFormulaInstances returns the Instances of each of the Formulas, sorted by ID.
+// Formulas whose Instances couldn't be listed are reported in the returned ExportErrors.
+func accountFormulaInstances(base, auth string, formulas []ce.Formula) ([]ce.FormulaInstance, error) {
+	perFormula := make([][]ce.FormulaInstance, len(formulas))
+	var errs exportErrorCollector
+	forEachConcurrently(len(formulas), func(i int) {
+		f := formulas[i]
+		instances, err := ce.GetInstancesOfFormula(f.ID, base, auth)
+		if err != nil {
+			errs.add(fmt.Errorf("Formula %v instances: %s", f.ID, err.Error()))
+			return
+		}
+		for j := range instances {
+			instances[j].Formula.ID = f.ID
+			instances[j].Formula.Name = f.Name
+		}
+		perFormula[i] = instances
+	})
+	var all []ce.FormulaInstance
+	for _, instances := range perFormula {
+		all = append(all, instances...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	return all, errs.err()
+}
+
+// getFormulaInstance retrieves a Formula Instance by ID
+func getFormulaInstance(id int, profilemap map[string]string) (ce.FormulaInstance, error) {
+	bodybytes, status, curlcmd, err := platformRequest("GET", fmt.Sprintf("/formulas/instances/%v", id), nil, profilemap)
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if err != nil {
+		return ce.FormulaInstance{}, err
+	}
+	if status == 404 {
+		return ce.FormulaInstance{}, fmt.Errorf("no Formula Instance %v", id)
+	}
+	if status != 200 {
+		return ce.FormulaInstance{}, fmt.Errorf("unable to get Formula Instance %v, %s", id, platformError(status, bodybytes).Error())
+	}
+	var instance ce.FormulaInstance
+	err = json.Unmarshal(bodybytes, &instance)
+	if err != nil {
+		return ce.FormulaInstance{}, fmt.Errorf("unable to understand Formula Instance %v, %s", id, err.Error())
+	}
+	return instance, nil
+}
+
+// formulaInstanceAndFormula retrieves a Formula Instance by ID, and its Formula
+func formulaInstanceAndFormula(arg string, profilemap map[string]string) (ce.FormulaInstance, *formula.Formula, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return ce.FormulaInstance{}, nil, fmt.Errorf("%s isn't a Formula Instance ID", arg)
+	}
+	instance, err := getFormulaInstance(id, profilemap)
+	if err != nil {
+		return ce.FormulaInstance{}, nil, err
+	}
+
+	formulabytes, err := pullFormula(strconv.Itoa(instance.Formula.ID), profilemap)
+	if err != nil {
+		return instance, nil, err
+	}
+	f, err := formula.Parse(formulabytes)
+	if err != nil {
+		return instance, nil, fmt.Errorf("unable to understand formula response, %s", err.Error())
+	}
+	if instance.Formula.Name == "" {
+		instance.Formula.Name = f.Name
+	}
+	return instance, f, nil
+}
+
+// formulaInstanceURI is the API path of a Formula Instance
+func formulaInstanceURI(instance ce.FormulaInstance) string {
+	return fmt.Sprintf("/formulas/%v/instances/%v", instance.Formula.ID, instance.ID)
+}
+
+// instanceConfiguration returns a copy of a Formula Instance's configuration values
+func instanceConfiguration(instance ce.FormulaInstance) map[string]interface{} {
+	values := make(map[string]interface{})
+	if c, ok := instance.Configuration.(map[string]interface{}); ok {
+		for k, v := range c {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// resolveConfiguration describes each configuration value against the Formula's
+// declarations, in the order declared, followed by values it doesn't declare
+func resolveConfiguration(f *formula.Formula, values map[string]interface{}, elementInstances map[int]ce.ElementInstance) []resolvedConfiguration {
+	var resolved []resolvedConfiguration
+	declared := f.ConfigKeys()
+	for _, c := range f.Configuration {
+		r := resolvedConfiguration{Key: c.Key, Name: c.Name, Type: c.Type, Required: c.Required, Value: values[c.Key]}
+		switch {
+		case r.Value == nil && c.Required:
+			r.Resolved = "missing, required"
+		case r.Value != nil && c.Type == formula.ConfigElementInstance:
+			id, ok := formula.ElementInstanceID(r.Value)
+			if i, found := elementInstances[id]; ok && found {
+				r.Resolved = fmt.Sprintf("%s %s", i.Element.Key, i.Name)
+				if i.Disabled {
+					r.Resolved += " (disabled)"
+				}
+			} else {
+				r.Resolved = "Element Instance not found"
+			}
+		}
+		resolved = append(resolved, r)
+	}
+	var undeclared []string
+	for k := range values {
+		if _, ok := declared[k]; !ok {
+			undeclared = append(undeclared, k)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, k := range undeclared {
+		resolved = append(resolved, resolvedConfiguration{Key: k, Value: values[k], Resolved: "not declared by the Formula"})
+	}
+	return resolved
+}
+
+func init() {
+	formulaInstancesCmd.AddCommand(listAllFormulaInstancesCmd)
+	listAllFormulaInstancesCmd.Flags().StringVar(&instanceListFormula, "formula", "", "Formula ID, or name as a glob or /regex/")
+	listAllFormulaInstancesCmd.Flags().StringVar(&instanceListActive, "active", "", "true or false, to list only active or inactive Instances")
+	listAllFormulaInstancesCmd.Flags().StringVar(&instanceListName, "name", "", "Instance name as a glob or /regex/")
+	listAllFormulaInstancesCmd.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+
+	formulaInstancesCmd.AddCommand(showFormulaInstanceCmd)
+
+	formulaInstancesCmd.AddCommand(updateFormulaInstanceCmd)
+	updateFormulaInstanceCmd.Flags().StringVar(&instanceUpdateConfiguration, "configuration", "", "instance configuration, as JSON or a JSON file")
+	updateFormulaInstanceCmd.Flags().BoolVar(&instanceUpdateReplace, "replace", false, "replace the whole configuration rather than the values given")
+
+	formulaInstancesCmd.AddCommand(enableFormulaInstanceCmd)
+	formulaInstancesCmd.AddCommand(disableFormulaInstanceCmd)
+}
@@ -0,0 +1,345 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom       int
+	migrateTo         int
+	migrateDeactivate bool
+	migrateDelete     bool
+	migrateDryRun     bool
+	migrateReport     string
+)
+
+// migrationReport records how the Instances of one Formula were moved to another
+type migrationReport struct {
+	Profile    string              `json:"profile"`
+	From       int                 `json:"from"`
+	To         int                 `json:"to"`
+	Started    time.Time           `json:"started"`
+	DryRun     bool                `json:"dryRun,omitempty"`
+	RolledBack bool                `json:"rolledBack,omitempty"`
+	Instances  []instanceMigration `json:"instances"`
+}
+
+// instanceMigration is what happened to one Formula Instance
+type instanceMigration struct {
+	OldID  int    `json:"oldId"`
+	Name   string `json:"name"`
+	Active bool   `json:"active"`
+	NewID  int    `json:"newId,omitempty"`
+	// Old is what became of the old Instance: kept, deactivated or deleted
+	Old   string `json:"old"`
+	Error string `json:"error,omitempty"`
+}
+
+// migrateFormulaInstancesCmd moves the Instances of a Formula onto another Formula
+var migrateFormulaInstancesCmd = &cobra.Command{
+	Use:   "migrate --from <formulaID> --to <formulaID>",
+	Short: "recreate the Instances of a Formula on another Formula",
+	Long: `Creates an Instance of the --to Formula for each Instance of the --from
+Formula, with the same name, configuration and state. Each configuration is
+checked against the --to Formula first, and nothing is created if any fails.
+With --deactivate-old or --delete-old, the new Instances are created inactive,
+the old ones deactivated and then the new ones activated, so events aren't
+handled twice; --delete-old then deletes the old Instances.
+If a step fails, the Instances created are deleted and old Instances that were
+deactivated are activated again; deleting old Instances is the last step and
+isn't undone. The mapping of old to new Instances is written to --report.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if migrateFrom == 0 || migrateTo == 0 {
+			fmt.Println("must supply --from and --to Formula IDs")
+			os.Exit(1)
+		}
+		if migrateFrom == migrateTo {
+			fmt.Println("--from and --to must be different Formulas")
+			os.Exit(1)
+		}
+
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		target, err := pullFormula(strconv.Itoa(migrateTo), profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		to, err := formula.Parse(target)
+		if err != nil {
+			fmt.Println("Unable to understand formula response", err.Error())
+			os.Exit(1)
+		}
+		instances, err := ce.GetInstancesOfFormula(migrateFrom, profilemap["base"], profilemap["auth"])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if len(instances) == 0 {
+			fmt.Printf("Formula %v has no Instances\n", migrateFrom)
+			return
+		}
+		sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+		for i := range instances {
+			instances[i].Formula.ID = migrateFrom
+		}
+
+		var elementInstances map[int]ce.ElementInstance
+		if formulaDeclaresElementInstances(to) {
+			elementInstances, err = accountElementInstances(profilemap["base"], profilemap["auth"])
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		invalid := 0
+		for _, i := range instances {
+			problems := checkInstanceConfiguration(to, instanceConfiguration(i), elementInstances)
+			if len(problems) > 0 {
+				fmt.Printf("Instance %v %s: ", i.ID, i.Name)
+				printConfigurationProblems(problems)
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			fmt.Printf("%v Instance(s) can't be migrated to Formula %v %s\n", invalid, to.ID, to.Name)
+			os.Exit(1)
+		}
+
+		m := &migration{
+			report: migrationReport{
+				Profile: profile,
+				From:    migrateFrom,
+				To:      migrateTo,
+				Started: time.Now().UTC(),
+				DryRun:  migrateDryRun,
+			},
+			instances:  instances,
+			profilemap: profilemap,
+			replace:    migrateDeactivate || migrateDelete,
+		}
+		err = m.run()
+		m.print()
+		if !migrateDryRun {
+			reportFile := migrateReport
+			if reportFile == "" {
+				reportFile = fmt.Sprintf("formula-migration-%v-%v.json", migrateFrom, migrateTo)
+			}
+			if werr := m.write(reportFile); werr != nil {
+				fmt.Println(werr.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("Report written to %s\n", reportFile)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// migration carries out a migration, step by step, so that it can be rolled back
+type migration struct {
+	report     migrationReport
+	instances  []ce.FormulaInstance
+	profilemap map[string]string
+	// replace is whether the old Instances are to be deactivated or deleted
+	replace bool
+}
+
+func (m *migration) run() error {
+	for _, i := range m.instances {
+		m.report.Instances = append(m.report.Instances, instanceMigration{OldID: i.ID, Name: i.Name, Active: i.Active, Old: "kept"})
+	}
+	if m.report.DryRun {
+		return nil
+	}
+
+	// create the new Instances, inactive for now if they're replacing active ones
+	for n, i := range m.instances {
+		config := ce.FormulaInstanceConfig{Name: i.Name, Active: i.Active && !m.replace, Configuration: instanceConfiguration(i)}
+		id, err := createFormulaInstance(strconv.Itoa(m.report.To), config, m.profilemap)
+		if err != nil {
+			m.report.Instances[n].Error = fmt.Sprintf("create: %s", err.Error())
+			return m.rollback(fmt.Errorf("unable to create Instance for %v %s: %s", i.ID, i.Name, err.Error()))
+		}
+		m.report.Instances[n].NewID = id
+	}
+	if !m.replace {
+		return nil
+	}
+
+	for n, i := range m.instances {
+		if !i.Active {
+			continue
+		}
+		if err := setFormulaInstanceActive(i, false, m.profilemap); err != nil {
+			m.report.Instances[n].Error = fmt.Sprintf("deactivate: %s", err.Error())
+			return m.rollback(fmt.Errorf("unable to deactivate %v %s: %s", i.ID, i.Name, err.Error()))
+		}
+		m.report.Instances[n].Old = "deactivated"
+	}
+	for n, i := range m.instances {
+		if !i.Active {
+			continue
+		}
+		created := ce.FormulaInstance{ID: m.report.Instances[n].NewID, Formula: ce.Formula{ID: m.report.To}}
+		if err := setFormulaInstanceActive(created, true, m.profilemap); err != nil {
+			m.report.Instances[n].Error = fmt.Sprintf("activate: %s", err.Error())
+			return m.rollback(fmt.Errorf("unable to activate %v: %s", created.ID, err.Error()))
+		}
+	}
+	if !migrateDelete {
+		return nil
+	}
+
+	// deleting is last as it can't be undone, so failures are reported but not rolled back
+	failed := 0
+	for n, i := range m.instances {
+		bodybytes, status, curlcmd, err := ce.DeleteFormulaInstance(m.profilemap["base"], m.profilemap["auth"], strconv.Itoa(i.ID))
+		if showCurl {
+			log.Println(curlcmd)
+		}
+		if err == nil && status != 200 {
+			err = platformError(status, bodybytes)
+		}
+		if err != nil {
+			m.report.Instances[n].Error = fmt.Sprintf("delete: %s", err.Error())
+			failed++
+			continue
+		}
+		m.report.Instances[n].Old = "deleted"
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v old Instance(s) couldn't be deleted", failed)
+	}
+	return nil
+}
+
+// rollback deletes the Instances created and reactivates old ones that were
+// deactivated, returning the error that caused it along with any of its own
+func (m *migration) rollback(cause error) error {
+	log.Println("Rolling back:", cause.Error())
+	m.report.RolledBack = true
+	errs := ExportErrors{cause}
+	for n := range m.report.Instances {
+		r := &m.report.Instances[n]
+		if r.Old == "deactivated" {
+			if err := setFormulaInstanceActive(m.instances[n], true, m.profilemap); err != nil {
+				errs = append(errs, fmt.Errorf("unable to reactivate %v: %s", r.OldID, err.Error()))
+			} else {
+				r.Old = "kept"
+			}
+		}
+		if r.NewID != 0 {
+			bodybytes, status, _, err := ce.DeleteFormulaInstance(m.profilemap["base"], m.profilemap["auth"], strconv.Itoa(r.NewID))
+			if err == nil && status != 200 {
+				err = platformError(status, bodybytes)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("unable to delete created Instance %v: %s", r.NewID, err.Error()))
+			} else {
+				r.NewID = 0
+			}
+		}
+	}
+	if len(errs) == 1 {
+		return cause
+	}
+	return errs
+}
+
+func (m *migration) print() {
+	if m.report.DryRun {
+		fmt.Printf("Would migrate %v Instance(s) from Formula %v to %v\n", len(m.report.Instances), m.report.From, m.report.To)
+	}
+	data := [][]string{}
+	for _, r := range m.report.Instances {
+		newID := ""
+		if r.NewID != 0 {
+			newID = strconv.Itoa(r.NewID)
+		}
+		old := r.Old
+		if m.report.DryRun && m.replace {
+			old = "deactivated"
+			if migrateDelete {
+				old = "deleted"
+			}
+		}
+		data = append(data, []string{strconv.Itoa(r.OldID), r.Name, strconv.FormatBool(r.Active), newID, old, r.Error})
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Old ID", "Instance", "active", "New ID", "Old Instance", "Error"})
+	table.SetBorder(false)
+	table.AppendBulk(data)
+	table.Render()
+}
+
+func (m *migration) write(filename string) error {
+	reportbytes, err := json.MarshalIndent(m.report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, reportbytes, 0644)
+}
+
+// createFormulaInstance creates an Instance of a Formula, returning its ID
+func createFormulaInstance(formulaID string, config ce.FormulaInstanceConfig, profilemap map[string]string) (int, error) {
+	bodybytes, status, curlcmd, err := ce.CreateFormulaInstance(profilemap["base"], profilemap["auth"], formulaID, config)
+	if err != nil {
+		return 0, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if status != 200 {
+		return 0, platformError(status, bodybytes)
+	}
+	var created ce.FormulaInstanceCreationResponse
+	err = json.Unmarshal(bodybytes, &created)
+	if err != nil || created.ID == 0 {
+		return 0, fmt.Errorf("no Instance ID in response")
+	}
+	return created.ID, nil
+}
+
+func init() {
+	formulaInstancesCmd.AddCommand(migrateFormulaInstancesCmd)
+	migrateFormulaInstancesCmd.Flags().IntVar(&migrateFrom, "from", 0, "ID of the Formula whose Instances are migrated")
+	migrateFormulaInstancesCmd.Flags().IntVar(&migrateTo, "to", 0, "ID of the Formula to create the Instances on")
+	migrateFormulaInstancesCmd.Flags().BoolVar(&migrateDeactivate, "deactivate-old", false, "deactivate the old Instances")
+	migrateFormulaInstancesCmd.Flags().BoolVar(&migrateDelete, "delete-old", false, "delete the old Instances")
+	migrateFormulaInstancesCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "show what would be migrated without changing anything")
+	migrateFormulaInstancesCmd.Flags().StringVar(&migrateReport, "report", "", "mapping report file (default formula-migration-<from>-<to>.json)")
+}
@@ -19,11 +19,11 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ghchinoy/ce-go/ce"
 	"github.com/spf13/cobra"
@@ -47,7 +47,15 @@ var moleculesCmd = &cobra.Command{
 var exportCmd = &cobra.Command{
 	Use:   "export [formulas|resources|transformations|all (default)]",
 	Short: "exports assets from the platform",
-	Long:  "Exports a set of assets",
+	Long: `Exports a set of assets
+Formula and Resource names can be selected with --include and excluded with --exclude,
+each a glob (e.g. 'Acme*') or a regular expression written as /pattern/; repeat
+the flag for several patterns, as a pattern may itself contain commas.
+Transformations can be limited to specific Elements with --element <key>,
+and Formulas to active ones with --active-only.
+With --canonical, files are written as indented JSON with sorted keys and without
+volatile fields such as id, createdDate and updatedDate, so that exporting an
+unchanged account twice produces identical files.`,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		// check for profile
@@ -57,6 +65,12 @@ var exportCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		exportSelection, err = newExportFilter(exportInclude, exportExclude, exportElements, exportActiveOnly)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
 		scope := []string{"formulas", "resources", "transformations"}
 		if len(args) > 0 {
 			// args[0] should be either "formulas" | "resources" | "transformations"
@@ -75,49 +89,48 @@ var exportCmd = &cobra.Command{
 			}
 		}
 
+		// failed calls don't end the export, they're reported once everything else is written
+		var exportErr error
+
 		if exportCombined {
 			vdr, err := CombineVirtualDataResourcesForExport(profilemap["base"], profilemap["auth"])
+			exportErr = mergeExportErrors(exportErr, err)
+			vdrbytes, err := json.Marshal(vdr)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			vdrbytes, err := json.Marshal(vdr)
+			name := fmt.Sprintf("%s.combined.vdr.json", strings.Replace(profile, " ", "", -1))
+			fmt.Printf("Exporting '%s' to %s/%s\n", "combined vdr", ".", name)
+			err = writeExportFile(fmt.Sprintf("%s/%s", ".", name), vdrbytes, assetVDR)
 			if err != nil {
 				fmt.Println(err.Error())
 				os.Exit(1)
 			}
-			//fmt.Printf("%s", vdrbytes)
-			name := fmt.Sprintf("%s.combined.vdr.json", strings.Replace(profile, " ", "", -1))
-			fmt.Printf("Exporting '%s' to %s/%s\n", "combined vdr", ".", name)
-			err = ioutil.WriteFile(fmt.Sprintf("%s/%s", ".", name), vdrbytes, 0644)
 		}
 
 		for _, v := range scope {
 			if v == "formulas" {
 				err = ExportAllFormulasToDir(profilemap["base"], profilemap["auth"], "./formulas")
-				if err != nil {
-					fmt.Println(err.Error())
-					os.Exit(1)
-				}
+				exportErr = mergeExportErrors(exportErr, err)
 			}
 			if !exportCombined {
 				if v == "resources" {
 					err = ExportAllResourcesToDir(profilemap["base"], profilemap["auth"], "./resources")
-					if err != nil {
-						fmt.Println(err.Error())
-						os.Exit(1)
-					}
+					exportErr = mergeExportErrors(exportErr, err)
 				}
 				if v == "transformations" {
 					err = ExportAllTransformationsToDir(profilemap["base"], profilemap["auth"], "./transformations")
-					if err != nil {
-						fmt.Println(err.Error())
-						os.Exit(1)
-					}
+					exportErr = mergeExportErrors(exportErr, err)
 				}
 			}
 		}
 
+		if exportErr != nil {
+			fmt.Println(exportErr.Error())
+			os.Exit(1)
+		}
+
 	},
 }
 
@@ -131,96 +144,44 @@ type AllVDR struct {
 // both the Resources and Transformations for the account.
 // Two top level keys of the JSON object are: objectDefinitions for Resources
 // and transformations for Transformations
-// This is very slow.
+// Resources and Transformations are gathered at the same time, through one
+// pool of exportConcurrency slots so no more than that many calls are made at
+// once. Failed calls are returned as ExportErrors alongside whatever could be
+// gathered.
 func CombineVirtualDataResourcesForExport(base, auth string) (AllVDR, error) {
 	var vdr AllVDR
 
-	// todo: Gather Resources and Gather Transformations, goroutines, each
+	pool := newCallPool()
+	var wg sync.WaitGroup
+	var definitions map[string][]byte
+	var txs map[string]map[string]interface{}
+	var resourcesErr, transformationsErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		definitions, resourcesErr = fetchResourceDefinitions(base, auth, pool)
+	}()
+	go func() {
+		defer wg.Done()
+		txs, transformationsErr = fetchElementTransformations(base, auth, pool)
+	}()
+	wg.Wait()
 
-	// Gather Resources
 	objs := make(map[string]ce.CommonResource)
-	resourcesListBytes, _, _, err := ce.ResourcesList(base, auth)
-	if err != nil {
-		return vdr, err
-	}
-	var resources []ce.CommonResource
-	err = json.Unmarshal(resourcesListBytes, &resources)
-	if err != nil {
-		return vdr, err
-	}
-	for _, r := range resources { // todo: goroutine
-		//log.Println("exporting", r.Name)
-		resourceBytes, _, _, err := ce.GetResourceDefinition(base, auth, r.Name, false)
-		if err != nil {
-			log.Println(err.Error())
-			break
-		}
+	var decodeErrs ExportErrors
+	for name, resourceBytes := range definitions {
 		var obj ce.CommonResource
-		err = json.Unmarshal(resourceBytes, &obj)
-		objs[r.Name] = obj
-	}
-	vdr.ObjectDefinitions = objs
-
-	// Gather Transformations
-	txs := make(map[string]map[string]interface{})
-	// Get all available transformations
-	log.Println("Getting all available Transformations")
-	bodybytes, status, _, err := ce.GetTransformations(base, auth)
-	if err != nil {
-		log.Println("Couldn't find any Transformations")
-		return vdr, nil
-	}
-	if status != 200 {
-		log.Println("No Transformations present")
-		return vdr, nil
-	}
-	transformationnames := make(map[string]ce.Transformation)
-	err = json.Unmarshal(bodybytes, &transformationnames)
-	if err != nil { // couldn't get transformation names
-		log.Println(err.Error()) // shouldn't have an effect on the assembly below
-	}
-	var elementids []int
-	namemap := make(map[int]string)
-	for k := range transformationnames {
-		bodybytes, status, _, err := ce.GetTransformationAssocation(base, auth, k)
-		if err != nil {
-			break
-		}
-		if status != 200 {
-			break
-		}
-		var associations []ce.AccountElement
-		err = json.Unmarshal(bodybytes, &associations)
-		if err != nil {
-			break
-		}
-		for _, v := range associations {
-			//fmt.Printf("%s: %s (%v)\n", k, v.Element.Key, v.Element.ID)
-			if _, ok := namemap[v.Element.ID]; !ok {
-				namemap[v.Element.ID] = v.Element.Key
-				elementids = append(elementids, v.Element.ID)
-			}
-		}
-	}
-	for _, v := range elementids {
-		transforms := make(map[string]interface{})
-		idstr := strconv.Itoa(v)
-		bodybytes, status, _, err := ce.GetTransformationsPerElement(base, auth, idstr)
-		if err != nil {
-			break
-		}
-		if status != 200 {
-			break
-		}
-		err = json.Unmarshal(bodybytes, &transforms)
+		err := json.Unmarshal(resourceBytes, &obj)
 		if err != nil {
-			log.Println(err.Error())
+			decodeErrs = append(decodeErrs, fmt.Errorf("Resource %s: %s", name, err.Error()))
+			continue
 		}
-		txs[namemap[v]] = transforms
+		objs[name] = obj
 	}
+	vdr.ObjectDefinitions = objs
 	vdr.Transformations = txs
 
-	return vdr, nil
+	return vdr, mergeExportErrors(resourcesErr, transformationsErr, decodeErrs)
 }
 
 // ExportAllTransformationsToDir creates a directory given a dirname and iterates through all
@@ -233,85 +194,37 @@ func ExportAllTransformationsToDir(base, auth string, dirname string) error {
 	}
 	log.Println("Finding all Transformations")
 
-	// Get all available transformations
-	log.Println("Getting all available Transformations")
-	bodybytes, status, _, err := ce.GetTransformations(base, auth)
-	if err != nil {
-		log.Println("Couldn't find any Transformations")
-		return err
-	}
-	if status != 200 {
-		log.Println("No Transformations present")
-		return nil
-	}
+	txs, fetchErr := fetchElementTransformations(base, auth, newCallPool())
 
-	log.Println("Assembling unique Element keys")
-	transformationnames := make(map[string]ce.Transformation)
-	err = json.Unmarshal(bodybytes, &transformationnames)
-	if err != nil {
-		log.Println("Couldn't marshal list of transformation names", err.Error())
-	}
-	var elementids []int
-	namemap := make(map[int]string)
-	for k := range transformationnames {
-		bodybytes, status, _, err := ce.GetTransformationAssocation(base, auth, k)
-		if err != nil {
-			break
-		}
-		if status != 200 {
-			break
-		}
-		var associations []ce.AccountElement
-		err = json.Unmarshal(bodybytes, &associations)
-		if err != nil {
-			break
-		}
-		for _, v := range associations {
-			//fmt.Printf("%s: %s\n", k, v.Element.Key)
-			if _, ok := namemap[v.Element.ID]; !ok {
-				namemap[v.Element.ID] = v.Element.Key
-				elementids = append(elementids, v.Element.ID)
-			}
-		}
-	}
-	fmt.Printf("%v", elementids)
-	log.Println("Exporting Transformations per Element")
-	for _, v := range elementids {
-		transforms := make(map[string]interface{})
-		idstr := strconv.Itoa(v)
-		bodybytes, status, _, err := ce.GetTransformationsPerElement(base, auth, idstr)
-		if err != nil {
-			break
-		}
-		log.Printf("%s (%s)", namemap[v], idstr)
-		//log.Printf("%s\n", bodybytes)
-		if status != 200 {
-			break
-		}
-		err = json.Unmarshal(bodybytes, &transforms)
-		if err != nil {
-			log.Println("unable to umarshal Transformation JSON", err.Error())
-			break
-		}
-
-		for n, t := range transforms {
-			filename := fmt.Sprintf("%s_%s.transformation.json", namemap[v], n)
+	var writeErrs ExportErrors
+	for _, key := range sortedKeys(txs) {
+		for n, t := range txs[key] {
+			filename := fmt.Sprintf("%s_%s.transformation.json", key, n)
 
 			b, err := json.Marshal(t)
 			if err != nil {
-				log.Println("Couldn't convert to bytes", err.Error())
+				writeErrs = append(writeErrs, fmt.Errorf("Transformation %s: %s", filename, err.Error()))
+				continue
 			}
-			//log.Printf("%s\n%s\n", n, b)
 			log.Printf("Exporting %s", filename)
-			err = ioutil.WriteFile(fmt.Sprintf("%s/%s", dirname, filename), b, 0644)
+			err = writeExportFile(fmt.Sprintf("%s/%s", dirname, filename), b, assetTransformation)
 			if err != nil {
-				log.Println("Error writing file")
-				break
+				writeErrs = append(writeErrs, fmt.Errorf("Transformation %s: %s", filename, err.Error()))
 			}
 		}
 	}
 
-	return nil
+	return mergeExportErrors(fetchErr, writeErrs)
+}
+
+// sortedKeys returns the keys of an Element Transformations map in order
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func interfaceToByte(key interface{}) ([]byte, error) {
@@ -325,6 +238,8 @@ func interfaceToByte(key interface{}) ([]byte, error) {
 }
 
 // ExportAllFormulasToDir creates a directory given and exports all Formula JSON files
+// With --canonical, the Formula JSON is written as returned by the platform rather
+// than as a ce.Formula, so no fields are lost before canonicalization.
 func ExportAllFormulasToDir(base, auth string, dirname string) error {
 	formulaListByes, _, _, err := ce.FormulasList(base, auth)
 	if err != nil {
@@ -335,55 +250,69 @@ func ExportAllFormulasToDir(base, auth string, dirname string) error {
 	if err != nil {
 		return err
 	}
+	var rawFormulas []json.RawMessage
+	err = json.Unmarshal(formulaListByes, &rawFormulas)
+	if err != nil {
+		return err
+	}
 
 	// create formulas dir
 	err = os.MkdirAll(dirname, os.ModePerm)
 	if err != nil {
 		return err
 	}
-	for _, f := range formulas {
+	var errs ExportErrors
+	for i, f := range formulas {
+		if !exportSelection.includesFormula(f.Name, f.Active) {
+			continue
+		}
 		name := fmt.Sprintf("%s.formula.json", strings.Replace(f.Name, " ", "", -1))
-		formulaBytes, err := json.Marshal(f)
-		if err != nil {
-			break
+		formulaBytes := []byte(rawFormulas[i])
+		if !exportCanonical {
+			formulaBytes, err = json.Marshal(f)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Formula %s: %s", f.Name, err.Error()))
+				continue
+			}
 		}
 		fmt.Printf("Exporting '%s' to %s/%s\n", f.Name, dirname, name)
-		err = ioutil.WriteFile(fmt.Sprintf("%s/%s", dirname, name), formulaBytes, 0644)
+		err = writeExportFile(fmt.Sprintf("%s/%s", dirname, name), formulaBytes, assetFormula)
 		if err != nil {
-			log.Printf("Couldn't write file %s/%s", dirname, name)
+			errs = append(errs, fmt.Errorf("Couldn't write file %s/%s: %s", dirname, name, err.Error()))
 		}
 	}
 
-	return nil
+	return mergeExportErrors(errs)
 }
 
 // ExportAllResourcesToDir writes out all the resources to the speceified irectory
 func ExportAllResourcesToDir(base, auth string, dirname string) error {
-	resourcesListBytes, _, _, err := ce.ResourcesList(base, auth)
+	err := os.MkdirAll(dirname, os.ModePerm)
 	if err != nil {
 		return err
 	}
-	var resources []ce.CommonResource
-	err = json.Unmarshal(resourcesListBytes, &resources)
-	if err != nil {
-		return err
+	definitions, fetchErr := fetchResourceDefinitions(base, auth, newCallPool())
+	if definitions == nil {
+		return fetchErr
 	}
-	err = os.MkdirAll(dirname, os.ModePerm)
-	if err != nil {
-		return err
+
+	var names []string
+	for name := range definitions {
+		names = append(names, name)
 	}
-	for _, r := range resources {
-		resourceBytes, _, _, err := ce.GetResourceDefinition(base, auth, r.Name, false)
+	sort.Strings(names)
+
+	var writeErrs ExportErrors
+	for _, name := range names {
+		filename := fmt.Sprintf("%s.obj.json", name)
+		fmt.Printf("Exporting %s to %s/%s\n", name, dirname, filename)
+		err = writeExportFile(fmt.Sprintf("%s/%s", dirname, filename), definitions[name], assetResource)
 		if err != nil {
-			log.Println(err.Error())
-			break
+			writeErrs = append(writeErrs, fmt.Errorf("Couldn't write file %s/%s: %s", dirname, filename, err.Error()))
 		}
-		name := fmt.Sprintf("%s.obj.json", r.Name)
-		fmt.Printf("Exporting %s to %s/%s\n", r.Name, dirname, name)
-		err = ioutil.WriteFile(fmt.Sprintf("%s/%s", dirname, name), resourceBytes, 0644)
 	}
 
-	return nil
+	return mergeExportErrors(fetchErr, writeErrs)
 }
 
 // cloneCmd is the command to clone assets between accounts
@@ -424,6 +353,12 @@ func init() {
 
 	moleculesCmd.AddCommand(exportCmd)
 	exportCmd.PersistentFlags().BoolVar(&exportCombined, "combined", false, "export resources+transformations as one file")
+	exportCmd.PersistentFlags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+	exportCmd.PersistentFlags().StringArrayVar(&exportInclude, "include", []string{}, "only export Formulas & Resources with names matching glob or /regex/, repeatable")
+	exportCmd.PersistentFlags().StringArrayVar(&exportExclude, "exclude", []string{}, "skip Formulas & Resources with names matching glob or /regex/, repeatable")
+	exportCmd.PersistentFlags().StringSliceVar(&exportElements, "element", []string{}, "only export Transformations for these Element keys")
+	exportCmd.PersistentFlags().BoolVar(&exportActiveOnly, "active-only", false, "only export active Formulas")
+	exportCmd.PersistentFlags().BoolVar(&exportCanonical, "canonical", false, "write diff-friendly JSON: sorted keys, no volatile fields")
 	moleculesCmd.AddCommand(cloneCmd)
 	cloneCmd.PersistentFlags().StringVar(&profileSource, "from", "default", "source profile name")
 	cloneCmd.PersistentFlags().StringVar(&profileTarget, "to", "", "target profile name")
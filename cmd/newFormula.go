@@ -0,0 +1,141 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var (
+	newTriggerType  string
+	newTemplate     string
+	newTemplateDir  string
+	newListTemplate bool
+)
+
+// newFormulaCmd creates Formula JSON from a template
+var newFormulaCmd = &cobra.Command{
+	Use:   "new <name> [file]",
+	Short: "Create a new Formula from a template",
+	Long: `Creates Formula JSON named name from a template, with a trigger given by
+--trigger manual|event|scheduled|request, writing it to file if given and
+otherwise printing it. Built-in templates are:
+
+  basic          a single script step
+  polling-sync   fetch records changed since the last run and sync each one
+  event-fanout   send each object of an event on to another service
+  paged-request  read every page of a resource with elementRequest
+
+Templates are also read from ~/.config/ce/templates/<template>.json, which take
+precedence over built-in ones with the same name. A template is Formula JSON in
+which {{name}} is replaced by the Formula's name; its trigger, if any, is the
+default and names the first steps. --list shows the templates available.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		templates, err := formulaTemplates(newTemplateDir)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if newListTemplate {
+			var names []string
+			for name := range templates {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			data := [][]string{}
+			for _, name := range names {
+				data = append(data, []string{name, templates[name].source})
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Template", "Source"})
+			table.SetBorder(false)
+			table.AppendBulk(data)
+			table.Render()
+			return
+		}
+
+		if len(args) < 1 {
+			fmt.Println("must supply a name for the Formula")
+			cmd.Help()
+			os.Exit(1)
+		}
+		tmpl, ok := templates[newTemplate]
+		if !ok {
+			fmt.Printf("no template named %s, use --list to see the templates available\n", newTemplate)
+			os.Exit(1)
+		}
+
+		data, err := formula.New(args[0], newTriggerType, tmpl.data)
+		if err != nil {
+			fmt.Printf("Unable to create Formula from template %s: %s\n", newTemplate, err.Error())
+			os.Exit(1)
+		}
+		if len(args) < 2 {
+			fmt.Printf("%s", data)
+			return
+		}
+		err = ioutil.WriteFile(args[1], data, 0644)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Formula %s written to %s\n", args[0], args[1])
+	},
+}
+
+// formulaTemplate is a template and where it came from
+type formulaTemplate struct {
+	data   []byte
+	source string
+}
+
+// formulaTemplates returns the built-in templates and those in dir, which take precedence
+func formulaTemplates(dir string) (map[string]formulaTemplate, error) {
+	templates := make(map[string]formulaTemplate)
+	for name, t := range formula.Templates {
+		templates[name] = formulaTemplate{data: []byte(t), source: "built-in"}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		templates[strings.TrimSuffix(filepath.Base(file), ".json")] = formulaTemplate{data: data, source: file}
+	}
+	return templates, nil
+}
+
+func init() {
+	formulasCmd.AddCommand(newFormulaCmd)
+	newFormulaCmd.Flags().StringVar(&newTriggerType, "trigger", "", "trigger type: manual, event, scheduled or request (default is the template's)")
+	newFormulaCmd.Flags().StringVar(&newTemplate, "template", formula.DefaultTemplate, "template name")
+	newFormulaCmd.Flags().StringVar(&newTemplateDir, "templates", filepath.Join(os.Getenv("HOME"), ".config", "ce", "templates"), "directory of user templates")
+	newFormulaCmd.Flags().BoolVar(&newListTemplate, "list", false, "list the templates available")
+}
@@ -0,0 +1,89 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+)
+
+// platformClient is shared by platform calls so connections are reused, and
+// gives up on a call the platform never answers
+var platformClient = &http.Client{Timeout: 2 * time.Minute}
+
+// platformRequest makes an authenticated call to a platform API that ce-go has
+// no function for, returning the response body, HTTP status and equivalent curl
+// command as the ce functions do. A non-nil body is sent as JSON.
+func platformRequest(method, uri string, body interface{}, profilemap map[string]string) ([]byte, int, string, error) {
+	respbytes, status, _, curlcmd, err := platformRequestHeader(method, uri, body, profilemap)
+	return respbytes, status, curlcmd, err
+}
+
+// platformRequestHeader is platformRequest also returning the response headers,
+// for calls such as paged lists that answer with more than a body
+func platformRequestHeader(method, uri string, body interface{}, profilemap map[string]string) ([]byte, int, http.Header, string, error) {
+	url := profilemap["base"] + uri
+
+	var bodybytes []byte
+	if body != nil {
+		var err error
+		bodybytes, err = json.Marshal(body)
+		if err != nil {
+			return nil, -1, nil, "", err
+		}
+	}
+
+	curl := []string{"curl", "-X", method, fmt.Sprintf("'%s'", url),
+		fmt.Sprintf("-H 'Authorization: %s'", profilemap["auth"]),
+		"-H 'Accept: application/json'",
+	}
+	if body != nil {
+		curl = append(curl, "-H 'Content-Type: application/json'", fmt.Sprintf("-d '%s'", bodybytes))
+	}
+	curlcmd := strings.Join(curl, " ")
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(bodybytes))
+	if err != nil {
+		return nil, -1, nil, curlcmd, err
+	}
+	req.Header.Add("Authorization", profilemap["auth"])
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	resp, err := platformClient.Do(req)
+	if err != nil {
+		return nil, -1, nil, curlcmd, err
+	}
+	defer resp.Body.Close()
+	respbytes, err := ioutil.ReadAll(resp.Body)
+	return respbytes, resp.StatusCode, resp.Header, curlcmd, err
+}
+
+// platformError describes a failed platform call, with the platform's message if it gave one
+func platformError(status int, bodybytes []byte) error {
+	var ficr ce.FormulaInstanceCreationResponse
+	if json.Unmarshal(bodybytes, &ficr) == nil && ficr.Message != "" {
+		return fmt.Errorf("HTTP %v: %s", status, ficr.Message)
+	}
+	return fmt.Errorf("HTTP %v", status)
+}
@@ -0,0 +1,255 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushFormulaID   int
+	pushMatchName   bool
+	pushForce       bool
+	pushDryRun      bool
+	pushDiffContext int
+)
+
+// pullFormulaCmd writes a Formula to a file for editing
+var pullFormulaCmd = &cobra.Command{
+	Use:   "pull <id> <file>",
+	Short: "Write a Formula template to a file for editing",
+	Long: `Writes the Formula template with the given ID to a file as indented JSON with
+sorted keys, ready to be edited and sent back with formulas push. The file keeps
+the Formula's id and updatedDate, which push uses to detect changes made on
+the platform since the pull.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("must supply an ID of a Formula and a file to write")
+			cmd.Help()
+			os.Exit(1)
+		}
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		bodybytes, err := pullFormula(args[0], profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		err = writeEditableFormula(args[1], bodybytes)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Formula %s written to %s\n", args[0], args[1])
+	},
+}
+
+// pushFormulaCmd updates an existing Formula from a file
+var pushFormulaCmd = &cobra.Command{
+	Use:   "push <file>",
+	Short: "Update an existing Formula template from a file",
+	Long: `Updates a Formula template in place from a Formula JSON file, keeping its
+Formula Instances, instead of deleting and re-importing it.
+The Formula to update is the one given by --id, the one with the same name when
+--match-name is given, or otherwise the id in the file.
+A diff against the platform's copy is shown first. The push is refused if the
+Formula was changed on the platform since the file was pulled, judged by
+updatedDate, unless --force is given. After a push the file is rewritten with
+the updated Formula, so it can be edited and pushed again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a path to a Formula JSON file")
+			cmd.Help()
+			os.Exit(1)
+		}
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		localbytes, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		local, err := formula.Parse(localbytes)
+		if err != nil {
+			fmt.Println("doesn't seem like a Formula", err.Error())
+			os.Exit(1)
+		}
+
+		id, err := pushTarget(local, profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		serverbytes, err := pullFormula(strconv.Itoa(id), profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		server, err := formula.Parse(serverbytes)
+		if err != nil {
+			fmt.Println("Unable to understand formula response", err.Error())
+			os.Exit(1)
+		}
+
+		// compare without ids and dates, which the platform manages
+		from, err := canonicalJSON(serverbytes, assetFormula)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		to, err := canonicalJSON(localbytes, assetFormula)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		diff := unifiedDiff(fmt.Sprintf("formula %v (%s)", id, profile), args[0], string(from), string(to), pushDiffContext)
+		if diff == "" {
+			fmt.Printf("Formula %v is already up to date\n", id)
+			return
+		}
+		fmt.Print(diff)
+
+		if local.UpdatedDate != server.UpdatedDate && !pushForce {
+			if local.UpdatedDate == "" {
+				fmt.Printf("%s has no updatedDate, pull Formula %v first or use --force\n", args[0], id)
+			} else {
+				fmt.Printf("Formula %v was changed on the platform at %s, after %s was pulled (%s); pull it again or use --force\n",
+					id, server.UpdatedDate, args[0], local.UpdatedDate)
+			}
+			os.Exit(1)
+		}
+		if pushDryRun {
+			return
+		}
+
+		var f ce.Formula
+		err = json.Unmarshal(localbytes, &f)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		f.ID = id
+
+		patchBytes, statuscode, err := ce.FormulaUpdate(strconv.Itoa(id), profilemap["base"], profilemap["auth"], f)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if statuscode != 200 {
+			fmt.Println(statuscode)
+			var ficr ce.FormulaInstanceCreationResponse
+			err = json.Unmarshal(patchBytes, &ficr)
+			if err != nil {
+				fmt.Println("Cannot process response, tried error message")
+				os.Exit(1)
+			}
+			fmt.Println(ficr.Message)
+			os.Exit(1)
+		}
+		if outputJSON {
+			fmt.Printf("%s\n", patchBytes)
+		}
+
+		// refresh the file, so its updatedDate matches the platform again
+		updated, err := pullFormula(strconv.Itoa(id), profilemap)
+		if err == nil {
+			err = writeEditableFormula(args[0], updated)
+		}
+		if err != nil {
+			fmt.Printf("Formula %v updated, but unable to refresh %s: %s\n", id, args[0], err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Formula %v updated\n", id)
+	},
+}
+
+// pushTarget returns the ID of the Formula a push updates
+func pushTarget(local *formula.Formula, profilemap map[string]string) (int, error) {
+	if pushFormulaID > 0 {
+		return pushFormulaID, nil
+	}
+	if !pushMatchName {
+		if local.ID == 0 {
+			return 0, fmt.Errorf("file has no Formula id, use --id or --match-name")
+		}
+		return local.ID, nil
+	}
+
+	bodybytes, statuscode, curlcmd, err := ce.FormulasList(profilemap["base"], profilemap["auth"])
+	if err != nil {
+		return 0, err
+	}
+	if showCurl {
+		log.Println(curlcmd)
+	}
+	if statuscode != 200 {
+		return 0, fmt.Errorf("unable to list formulas, HTTP %v", statuscode)
+	}
+	var formulas []ce.Formula
+	err = json.Unmarshal(bodybytes, &formulas)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for _, f := range formulas {
+		if f.Name == local.Name {
+			ids = append(ids, f.ID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("no Formula named %s, use formulas import to create it", local.Name)
+	case 1:
+		return ids[0], nil
+	}
+	return 0, fmt.Errorf("%v Formulas are named %s %v, use --id", len(ids), local.Name, ids)
+}
+
+// writeEditableFormula writes Formula JSON indented with sorted keys, keeping every field
+func writeEditableFormula(filename string, data []byte) error {
+	editable, err := canonicalJSON(data, "")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, editable, 0644)
+}
+
+func init() {
+	formulasCmd.AddCommand(pullFormulaCmd)
+	formulasCmd.AddCommand(pushFormulaCmd)
+	pushFormulaCmd.Flags().IntVar(&pushFormulaID, "id", 0, "ID of the Formula to update")
+	pushFormulaCmd.Flags().BoolVar(&pushMatchName, "match-name", false, "update the Formula with the same name")
+	pushFormulaCmd.Flags().BoolVar(&pushForce, "force", false, "push even if the Formula changed on the platform since it was pulled")
+	pushFormulaCmd.Flags().BoolVar(&pushDryRun, "dry-run", false, "only show the diff")
+	pushFormulaCmd.Flags().IntVar(&pushDiffContext, "context", 3, "lines of context in the diff")
+}
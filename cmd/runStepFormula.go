@@ -0,0 +1,128 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runStepTrigger string
+	runStepContext string
+)
+
+// runStepFormulaCmd runs a Formula script step locally
+var runStepFormulaCmd = &cobra.Command{
+	Use:   "run-step <id|file|dir> <step>",
+	Short: "Run a Formula script step locally",
+	Long: `Runs a script or filter step of a Formula in an embedded JavaScript engine,
+with the trigger, steps, info, config and done() the platform provides, and
+prints the value the step passes to done(), or the error it throws.
+--trigger is a JSON file of the trigger, and --context a JSON file with the
+values of earlier steps, the configuration and the execution info:
+
+  {"steps": {"getContacts": {...}}, "config": {"crm.instance": 123}, "info": {}}
+
+A trigger in --context is used if --trigger isn't given. Lines written with
+console.log are shown before the value. The exit code is non-zero if the step fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("must supply a Formula and the name of a step")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		data, err := formulaSourceBytes(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		f, err := formula.Parse(data)
+		if err != nil {
+			fmt.Println("doesn't seem like a Formula", err.Error())
+			os.Exit(1)
+		}
+		step, ok := f.Step(args[1])
+		if !ok {
+			fmt.Printf("no step named %s in %s\n", args[1], args[0])
+			os.Exit(1)
+		}
+
+		var ctx formula.StepContext
+		if runStepContext != "" {
+			err = readJSONFile(runStepContext, &ctx)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		if runStepTrigger != "" {
+			err = readJSONFile(runStepTrigger, &ctx.Trigger)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		result, err := formula.RunScript(*step, ctx)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if outputJSON {
+			resultbytes, _ := json.MarshalIndent(result, "", "  ")
+			fmt.Printf("%s\n", resultbytes)
+		} else {
+			for _, l := range result.Logs {
+				fmt.Println("console:", l)
+			}
+			if result.Error != "" {
+				fmt.Println("error:", result.Error)
+			} else {
+				valuebytes, _ := json.MarshalIndent(result.Value, "", "  ")
+				fmt.Printf("%s\n", valuebytes)
+			}
+		}
+		if result.Error != "" {
+			os.Exit(1)
+		}
+	},
+}
+
+// readJSONFile decodes a JSON file into v
+func readJSONFile(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return nil
+}
+
+func init() {
+	formulasCmd.AddCommand(runStepFormulaCmd)
+	runStepFormulaCmd.Flags().StringVar(&runStepTrigger, "trigger", "", "JSON file of the trigger")
+	runStepFormulaCmd.Flags().StringVar(&runStepContext, "context", "", "JSON file of steps, config and info")
+}
@@ -0,0 +1,367 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/spf13/cobra"
+)
+
+var (
+	searchIn      []string
+	searchDir     string
+	searchContext int
+	searchIgnore  bool
+)
+
+// Kinds of asset searched
+const (
+	searchFormulas        = "formulas"
+	searchTransformations = "transformations"
+	searchResources       = "resources"
+)
+
+// searchCmd finds text in Formulas, Transformations and Resources
+var searchCmd = &cobra.Command{
+	Use:   "search <regex>",
+	Short: "Search Formulas, Transformations and Resources",
+	Long: `Searches the Formulas, Transformations and Resources of an account for a
+regular expression, for instance to find every step script or Transformation
+that uses a field before it's renamed. Each match is reported with the asset's
+name, where in it the match is, such as the Formula step and property, the line
+number and the lines around it.
+--in limits the search to formulas, transformations or resources.
+--dir searches a local export, as written by molecules export or backup run,
+instead of the account.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a regular expression to search for")
+			cmd.Help()
+			os.Exit(1)
+		}
+		expr := args[0]
+		if searchIgnore {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			fmt.Println("Invalid regular expression", err.Error())
+			os.Exit(1)
+		}
+		for _, kind := range searchIn {
+			if kind != searchFormulas && kind != searchTransformations && kind != searchResources {
+				fmt.Printf("unknown asset %s, --in takes formulas, transformations and resources\n", kind)
+				os.Exit(1)
+			}
+		}
+
+		var docs []searchDocument
+		if searchDir != "" {
+			docs, err = localSearchDocuments(searchDir, searchIn)
+		} else {
+			var profilemap map[string]string
+			profilemap, err = getAuth(profile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			docs, err = liveSearchDocuments(profilemap["base"], profilemap["auth"], searchIn)
+		}
+		if err != nil {
+			// search what could be retrieved, and say what couldn't
+			log.Println(err.Error())
+		}
+
+		var matches []searchMatch
+		for _, d := range docs {
+			matches = append(matches, d.search(re, searchContext)...)
+		}
+
+		if outputJSON {
+			matchbytes, _ := json.MarshalIndent(matches, "", "  ")
+			fmt.Printf("%s\n", matchbytes)
+		} else {
+			for _, m := range matches {
+				m.print()
+			}
+			fmt.Printf("%v matches in %v assets searched\n", len(matches), len(docs))
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+// searchDocument is an asset to search
+type searchDocument struct {
+	Kind string
+	Name string
+	Doc  interface{}
+}
+
+// searchMatch is a line of an asset matching the search
+type searchMatch struct {
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	Step     string   `json:"step,omitempty"`
+	Location string   `json:"location"`
+	Line     int      `json:"line"`
+	Text     string   `json:"text"`
+	Before   []string `json:"before,omitempty"`
+	After    []string `json:"after,omitempty"`
+}
+
+func (m searchMatch) print() {
+	where := m.Location
+	if m.Step != "" {
+		where = fmt.Sprintf("step %s %s", m.Step, m.Location)
+	}
+	fmt.Printf("%s %s: %s line %v\n", strings.TrimSuffix(m.Kind, "s"), m.Name, where, m.Line)
+	for i, l := range m.Before {
+		fmt.Printf("  %5d | %s\n", m.Line-len(m.Before)+i, l)
+	}
+	fmt.Printf("> %5d | %s\n", m.Line, m.Text)
+	for i, l := range m.After {
+		fmt.Printf("  %5d | %s\n", m.Line+1+i, l)
+	}
+	fmt.Println()
+}
+
+// search finds the lines of every string in the document that match re
+func (d searchDocument) search(re *regexp.Regexp, context int) []searchMatch {
+	var matches []searchMatch
+	walkSearchStrings(d.Doc, nil, func(path []string, s string) {
+		if !re.MatchString(s) {
+			return
+		}
+		step, location := "", strings.Join(path, ".")
+		// Formula steps are reported by name
+		if d.Kind == searchFormulas && len(path) > 1 && path[0] == "steps" {
+			step, location = path[1], strings.Join(path[2:], ".")
+		}
+		lines := strings.Split(s, "\n")
+		for i, l := range lines {
+			if !re.MatchString(l) {
+				continue
+			}
+			m := searchMatch{Kind: d.Kind, Name: d.Name, Step: step, Location: location, Line: i + 1, Text: l}
+			start := i - context
+			if start < 0 {
+				start = 0
+			}
+			end := i + 1 + context
+			if end > len(lines) {
+				end = len(lines)
+			}
+			m.Before = lines[start:i]
+			m.After = lines[i+1 : end]
+			matches = append(matches, m)
+		}
+	})
+	return matches
+}
+
+// walkSearchStrings calls fn with every string in a decoded JSON value and its path.
+// Array elements are named by their name, key or path field when they have one.
+func walkSearchStrings(v interface{}, path []string, fn func([]string, string)) {
+	switch t := v.(type) {
+	case string:
+		fn(path, t)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkSearchStrings(t[k], append(path[:len(path):len(path)], k), fn)
+		}
+	case []interface{}:
+		for i, child := range t {
+			label := strconv.Itoa(i)
+			if m, ok := child.(map[string]interface{}); ok {
+				for _, field := range []string{"name", "key", "path"} {
+					if s, ok := m[field].(string); ok && s != "" {
+						label = s
+						break
+					}
+				}
+			}
+			walkSearchStrings(child, append(path[:len(path):len(path)], label), fn)
+		}
+	}
+}
+
+// searches reports whether kind is among the kinds to search
+func searches(kinds []string, kind string) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// liveSearchDocuments retrieves the assets to search from the account
+func liveSearchDocuments(base, auth string, kinds []string) ([]searchDocument, error) {
+	var docs []searchDocument
+	var errs ExportErrors
+
+	if searches(kinds, searchFormulas) {
+		bodybytes, status, curlcmd, err := ce.FormulasList(base, auth)
+		if showCurl {
+			log.Println(curlcmd)
+		}
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("Formulas: %s", err.Error()))
+		case status != 200:
+			errs = append(errs, fmt.Errorf("Formulas: HTTP Status Code %v", status))
+		default:
+			var formulas []interface{}
+			err = decodeJSON(bodybytes, &formulas)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Formulas: %s", err.Error()))
+			}
+			for _, f := range formulas {
+				docs = append(docs, searchDocument{Kind: searchFormulas, Name: documentName(f, ""), Doc: f})
+			}
+		}
+	}
+
+	if searches(kinds, searchTransformations) {
+		txs, err := fetchElementTransformations(base, auth, newCallPool())
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, key := range sortedKeys(txs) {
+			var names []string
+			for name := range txs[key] {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				docs = append(docs, searchDocument{Kind: searchTransformations, Name: key + "/" + name, Doc: txs[key][name]})
+			}
+		}
+	}
+
+	if searches(kinds, searchResources) {
+		definitions, err := fetchResourceDefinitions(base, auth, newCallPool())
+		if err != nil {
+			errs = append(errs, err)
+		}
+		var names []string
+		for name := range definitions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var doc interface{}
+			if err := decodeJSON(definitions[name], &doc); err != nil {
+				errs = append(errs, fmt.Errorf("Resource %s: %s", name, err.Error()))
+				continue
+			}
+			docs = append(docs, searchDocument{Kind: searchResources, Name: name, Doc: doc})
+		}
+	}
+
+	return docs, mergeExportErrors(errs)
+}
+
+// exportSuffixes are the file name endings of each kind of asset in an export
+var exportSuffixes = map[string]string{
+	searchFormulas:        ".formula.json",
+	searchTransformations: ".transformation.json",
+	searchResources:       ".obj.json",
+}
+
+// localSearchDocuments reads the assets to search from an export directory, with
+// formulas, transformations and resources subdirectories
+func localSearchDocuments(dir string, kinds []string) ([]searchDocument, error) {
+	var docs []searchDocument
+	var errs ExportErrors
+	for _, kind := range []string{searchFormulas, searchTransformations, searchResources} {
+		if !searches(kinds, kind) {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, kind, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			var doc interface{}
+			if err := decodeJSON(data, &doc); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", file, err.Error()))
+				continue
+			}
+			name := strings.TrimSuffix(filepath.Base(file), exportSuffixes[kind])
+			if kind == searchFormulas {
+				name = documentName(doc, name)
+			}
+			docs = append(docs, searchDocument{Kind: kind, Name: name, Doc: doc})
+		}
+	}
+	return docs, mergeExportErrors(errs)
+}
+
+// documentName returns the name field of an asset, or fallback if it has none
+func documentName(doc interface{}, fallback string) string {
+	if m, ok := doc.(map[string]interface{}); ok {
+		if name, ok := m["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return fallback
+}
+
+// decodeJSON decodes JSON keeping numbers as they were written
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func init() {
+	RootCmd.AddCommand(searchCmd)
+
+	searchCmd.PersistentFlags().StringVar(&profile, "profile", "default", "profile name")
+	searchCmd.PersistentFlags().BoolVarP(&outputJSON, "json", "j", false, "output as json")
+	searchCmd.PersistentFlags().BoolVarP(&showCurl, "curl", "c", false, "show curl command")
+	searchCmd.Flags().StringSliceVar(&searchIn, "in", nil, "assets to search: formulas, transformations, resources (default all)")
+	searchCmd.Flags().StringVar(&searchDir, "dir", "", "search an export directory instead of the account")
+	searchCmd.Flags().IntVarP(&searchContext, "context", "C", 2, "lines of context around each match")
+	searchCmd.Flags().BoolVarP(&searchIgnore, "ignore-case", "i", false, "match case insensitively")
+}
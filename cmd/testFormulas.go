@@ -0,0 +1,281 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+var (
+	testJUnitFile string
+	testVerbose   bool
+)
+
+// testFormulasCmd runs Formula test fixtures
+var testFormulasCmd = &cobra.Command{
+	Use:   "test <dir>",
+	Short: "Run Formula test fixtures offline",
+	Long: `Finds *.test.json fixtures in a directory and simulates each one's Formula
+offline: scripts and filters run in an embedded JavaScript engine, elementRequest
+and httpRequest steps are answered from stubbed responses, and loops go round
+their lists. A fixture looks like:
+
+  {
+    "name": "creates a contact",
+    "formula": "../formula.json",
+    "trigger": {"type": "event", "event": {"eventType": "CREATED"}},
+    "config": {"crm.instance": 1234},
+    "stubs": {"getContact": {"status": 200, "body": {"id": 1}}},
+    "expect": {
+      "status": "success",
+      "path": ["isCreated", "getContact", "buildContact"],
+      "visits": ["buildContact"],
+      "notVisits": ["notifyFailure"],
+      "steps": {"buildContact": {"id": 1}}
+    }
+  }
+
+formula is relative to the fixture, and can be an unpacked Formula directory.
+A list of stubs answers successive calls of a step. Expected step values only
+need the fields given. Results are printed, and written as JUnit XML with
+--junit for CI; the exit code is non-zero if any fixture fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a directory of test fixtures")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		files, err := fixtureFiles(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Printf("no *%s fixtures in %s\n", formula.FixtureSuffix, args[0])
+			os.Exit(1)
+		}
+
+		var results []fixtureResult
+		failed := 0
+		for _, file := range files {
+			r := runFixture(file)
+			results = append(results, r)
+			if !r.passed() {
+				failed++
+			}
+			printFixtureResult(r)
+		}
+		fmt.Printf("\n%v passed, %v failed\n", len(results)-failed, failed)
+
+		if testJUnitFile != "" {
+			err = writeJUnit(testJUnitFile, results)
+			if err != nil {
+				fmt.Println("Unable to write JUnit report", err.Error())
+				os.Exit(1)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// fixtureResult is the outcome of one fixture
+type fixtureResult struct {
+	File      string
+	Name      string
+	Formula   string
+	Execution *formula.Execution
+	// Failures are unmet expectations, Err a fixture that couldn't be run
+	Failures []string
+	Err      error
+	Duration time.Duration
+}
+
+func (r fixtureResult) passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// fixtureFiles returns the fixture files in a directory
+func fixtureFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(p, formula.FixtureSuffix) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func runFixture(file string) (r fixtureResult) {
+	start := time.Now()
+	r = fixtureResult{File: file, Name: file}
+	defer func() { r.Duration = time.Since(start) }()
+
+	fx, err := formula.LoadFixture(file)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Name = fx.Name
+	if fx.Formula == "" {
+		r.Err = fmt.Errorf("fixture doesn't name a formula")
+		return r
+	}
+	data, err := formulaSourceBytes(fx.FormulaPath())
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	f, err := formula.Parse(data)
+	if err != nil {
+		r.Err = fmt.Errorf("%s doesn't seem like a Formula: %s", fx.FormulaPath(), err.Error())
+		return r
+	}
+	r.Formula = f.Name
+
+	r.Execution, err = formula.Simulate(f, fx.Trigger, fx.Config, fx.Stubs)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Failures = fx.Check(r.Execution)
+	return r
+}
+
+func printFixtureResult(r fixtureResult) {
+	switch {
+	case r.Err != nil:
+		fmt.Printf("ERROR %s (%s)\n      %s\n", r.Name, r.File, r.Err.Error())
+		return
+	case r.passed():
+		fmt.Printf("PASS  %s\n", r.Name)
+	default:
+		fmt.Printf("FAIL  %s (%s)\n", r.Name, r.File)
+		for _, f := range r.Failures {
+			fmt.Printf("      %s\n", f)
+		}
+	}
+	if testVerbose || !r.passed() {
+		fmt.Printf("      path: %s\n", strings.Join(r.Execution.Path, " > "))
+		for _, l := range r.Execution.Logs {
+			fmt.Printf("      console: %s\n", l)
+		}
+	}
+}
+
+// JUnit XML, as read by CI servers
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes fixture results as a JUnit XML report, one suite per Formula
+func writeJUnit(filename string, results []fixtureResult) error {
+	suites := make(map[string]*junitTestSuite)
+	var names []string
+	durations := make(map[string]time.Duration)
+	for _, r := range results {
+		suiteName := r.Formula
+		if suiteName == "" {
+			suiteName = filepath.Dir(r.File)
+		}
+		suite, ok := suites[suiteName]
+		if !ok {
+			suite = &junitTestSuite{Name: suiteName}
+			suites[suiteName] = suite
+			names = append(names, suiteName)
+		}
+		tc := junitTestCase{
+			ClassName: suiteName,
+			Name:      r.Name,
+			Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+		}
+		switch {
+		case r.Err != nil:
+			tc.Error = &junitProblem{Message: r.Err.Error(), Text: r.File}
+			suite.Errors++
+		case len(r.Failures) > 0:
+			tc.Failure = &junitProblem{Message: r.Failures[0], Text: strings.Join(r.Failures, "\n")}
+			suite.Failures++
+		}
+		if r.Execution != nil {
+			tc.SystemOut = "path: " + strings.Join(r.Execution.Path, " > ")
+			if len(r.Execution.Logs) > 0 {
+				tc.SystemOut += "\n" + strings.Join(r.Execution.Logs, "\n")
+			}
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		durations[suiteName] += r.Duration
+	}
+
+	var report junitTestSuites
+	for _, name := range names {
+		suite := suites[name]
+		suite.Time = fmt.Sprintf("%.3f", durations[name].Seconds())
+		report.Suites = append(report.Suites, *suite)
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func init() {
+	formulasCmd.AddCommand(testFormulasCmd)
+	testFormulasCmd.Flags().StringVar(&testJUnitFile, "junit", "", "write a JUnit XML report to this file")
+	testFormulasCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "show the path and console output of passing fixtures too")
+}
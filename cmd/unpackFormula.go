@@ -0,0 +1,91 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ghchinoy/cectl/formula"
+	"github.com/spf13/cobra"
+)
+
+// unpackFormulaCmd splits a Formula into editable files
+var unpackFormulaCmd = &cobra.Command{
+	Use:   "unpack <id|file> <dir>",
+	Short: "Unpack a Formula into a directory of editable files",
+	Long: `Writes a Formula, by ID or from a Formula JSON file, into a directory:
+formula.json holds the Formula without its scripts or configuration,
+steps/<name>.js the JavaScript of each script and filter step, and
+configuration.json the Formula's configuration. Scripts can then be edited,
+linted and reviewed as ordinary files, and formulas pack gives back the Formula.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			fmt.Println("must supply a Formula ID or Formula JSON file, and a directory")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		data, err := formulaSourceBytes(args[0])
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		err = formula.Unpack(data, args[1])
+		if err != nil {
+			fmt.Println("Unable to unpack Formula", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Formula unpacked to %s\n", args[1])
+	},
+}
+
+// packFormulaCmd reassembles an unpacked Formula
+var packFormulaCmd = &cobra.Command{
+	Use:   "pack <dir> [file]",
+	Short: "Pack a directory written by formulas unpack into Formula JSON",
+	Long: `Reassembles the Formula JSON from a directory written by formulas unpack,
+putting each steps/<name>.js back as its step's script and configuration.json
+back as the configuration. The Formula is written to file if given, otherwise
+printed, ready for formulas import or formulas push.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			fmt.Println("must supply a directory written by formulas unpack")
+			cmd.Help()
+			os.Exit(1)
+		}
+
+		data, err := formula.Pack(args[0])
+		if err != nil {
+			fmt.Println("Unable to pack Formula", err.Error())
+			os.Exit(1)
+		}
+		if len(args) < 2 {
+			fmt.Printf("%s", data)
+			return
+		}
+		err = ioutil.WriteFile(args[1], data, 0644)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	formulasCmd.AddCommand(unpackFormulaCmd)
+	formulasCmd.AddCommand(packFormulaCmd)
+}
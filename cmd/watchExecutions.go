@@ -0,0 +1,317 @@
+// Copyright © 2017 G. Hussain Chinoy <ghchinoy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghchinoy/ce-go/ce"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchFormula       int
+	watchAll           bool
+	watchInterval      time.Duration
+	watchDetails       bool
+	watchExitOnFailure bool
+)
+
+// watchEvent is a new execution or a change of an execution's status
+type watchEvent struct {
+	Time        time.Time `json:"time"`
+	ExecutionID int       `json:"executionId"`
+	InstanceID  int       `json:"instanceId"`
+	Instance    string    `json:"instance,omitempty"`
+	Status      string    `json:"status"`
+	Previous    string    `json:"previous,omitempty"`
+	Duration    string    `json:"duration,omitempty"`
+}
+
+// watchExecutionsCmd follows the executions of Formula Instances as they happen
+var watchExecutionsCmd = &cobra.Command{
+	Use:   "watch [instanceID...]",
+	Short: "follow executions as they happen",
+	Long: `Checks the executions of Formula Instances every --interval and prints new
+executions and changes of status as they happen, like tail -f. Watch Instances
+by ID, the Instances of a Formula with --formula or every Instance with --all.
+Executions created more than a minute before the watch started are not followed.
+Failures are shown in red on a terminal; --details shows the error of the step
+that failed, and --exit-on-failure stops with a non-zero exit at the first
+failure. With --json each event is printed as a line of JSON.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if watchInterval <= 0 {
+			fmt.Println("--interval must be more than 0")
+			os.Exit(1)
+		}
+
+		// check for profile
+		profilemap, err := getAuth(profile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		instances, err := watchTargets(args, profilemap)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if len(instances) == 0 {
+			fmt.Println("no Formula Instances to watch")
+			os.Exit(1)
+		}
+		if !outputJSON {
+			fmt.Printf("Watching executions of %v Formula Instance(s), every %s\n", len(instances), watchInterval)
+		}
+
+		w := &executionWatch{
+			instances:  instances,
+			profilemap: profilemap,
+			start:      time.Now(),
+			seen:       make(map[int]watchedExecution),
+			latest:     make(map[int]time.Time),
+			baselined:  make(map[int]bool),
+		}
+		for {
+			if failed := w.poll(); failed && watchExitOnFailure {
+				os.Exit(1)
+			}
+			time.Sleep(watchInterval)
+		}
+	},
+}
+
+// watchTargets returns the Formula Instances to watch
+func watchTargets(args []string, profilemap map[string]string) ([]ce.FormulaInstance, error) {
+	var instances []ce.FormulaInstance
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s isn't a Formula Instance ID", arg)
+		}
+		instances = append(instances, ce.FormulaInstance{ID: id})
+	}
+	switch {
+	case watchAll:
+		formulas, err := listFormulas(profilemap["base"], profilemap["auth"])
+		if err != nil {
+			return nil, err
+		}
+		all, err := accountFormulaInstances(profilemap["base"], profilemap["auth"], formulas)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, all...)
+	case watchFormula != 0:
+		formulaInstances, err := ce.GetInstancesOfFormula(watchFormula, profilemap["base"], profilemap["auth"])
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, formulaInstances...)
+	}
+	return instances, nil
+}
+
+// watchMargin is how far back before the window of interest executions are
+// listed, allowing for the platform's clock and the time it takes to list them
+const watchMargin = time.Minute
+
+// executionWatch remembers the executions that haven't finished yet, and how
+// far each Instance's executions have been looked at
+type executionWatch struct {
+	instances  []ce.FormulaInstance
+	profilemap map[string]string
+	start      time.Time
+	mu         sync.Mutex
+	// seen holds the executions not yet finished, by ID; they're forgotten once
+	// their finish has been reported
+	seen map[int]watchedExecution
+	// latest is the newest update among an Instance's executions at the last
+	// check; an execution that's finished when it's first seen is new only if
+	// it was updated after that
+	latest map[int]time.Time
+	// baselined holds the Instances whose executions have been checked once; that
+	// first check only notes the executions there are already
+	baselined map[int]bool
+}
+
+// watchedExecution is an unfinished execution as it was at the last check
+type watchedExecution struct {
+	instanceID int
+	status     string
+	created    time.Time
+}
+
+// since returns when the executions to list for an Instance start: the oldest
+// one still unfinished, or the last update seen, or the start of the watch
+func (w *executionWatch) since(instanceID int) time.Time {
+	since := w.start
+	if latest, ok := w.latest[instanceID]; ok && !latest.IsZero() {
+		since = latest
+	}
+	for _, e := range w.seen {
+		if e.instanceID == instanceID && e.created.Before(since) {
+			since = e.created
+		}
+	}
+	return since.Add(-watchMargin)
+}
+
+// poll checks each Instance's executions, reporting what changed since the
+// last check, and returns whether an execution failed
+func (w *executionWatch) poll() bool {
+	var events []watchEvent
+	forEachConcurrently(len(w.instances), func(i int) {
+		instance := w.instances[i]
+		w.mu.Lock()
+		q := executionQuery{Since: w.since(instance.ID)}
+		w.mu.Unlock()
+		executions, err := listExecutions(strconv.Itoa(instance.ID), q, w.profilemap)
+		if err != nil {
+			// carry on watching, the next check may work
+			log.Println(err.Error())
+			return
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		report := w.baselined[instance.ID]
+		w.baselined[instance.ID] = true
+		latest := w.latest[instance.ID]
+		newest := latest
+		for _, e := range executions {
+			if e.UpdatedDate.After(newest) {
+				newest = e.UpdatedDate
+			}
+			previous, ok := w.seen[e.ID]
+			if ok && previous.status == e.Status {
+				continue
+			}
+			finished := executionFinished(e.Status)
+			if !ok && finished && !e.UpdatedDate.After(latest) {
+				// finished before the last check, and reported then or before the watch
+				continue
+			}
+			if finished {
+				delete(w.seen, e.ID)
+			} else {
+				w.seen[e.ID] = watchedExecution{instanceID: instance.ID, status: e.Status, created: e.CreateDate}
+			}
+			if !report {
+				continue
+			}
+			event := watchEvent{
+				Time:        e.UpdatedDate,
+				ExecutionID: e.ID,
+				InstanceID:  instance.ID,
+				Instance:    instance.Name,
+				Status:      e.Status,
+				Previous:    previous.status,
+			}
+			if finished {
+				event.Duration = executionDuration(e.Status, e.CreateDate, e.UpdatedDate)
+			}
+			events = append(events, event)
+		}
+		w.latest[instance.ID] = newest
+	})
+	sortWatchEvents(events)
+	failed := false
+	for _, e := range events {
+		e.print()
+		if e.Status == executionFailed {
+			failed = true
+			if watchDetails {
+				w.printFailure(e.ExecutionID)
+			}
+		}
+	}
+	return failed
+}
+
+func (e watchEvent) print() {
+	if outputJSON {
+		eventbytes, _ := json.Marshal(e)
+		fmt.Printf("%s\n", eventbytes)
+		return
+	}
+	instance := strconv.Itoa(e.InstanceID)
+	if e.Instance != "" {
+		instance = fmt.Sprintf("%v %s", e.InstanceID, e.Instance)
+	}
+	status := e.Status
+	if e.Previous != "" {
+		status = e.Previous + " -> " + e.Status
+	}
+	line := fmt.Sprintf("%s  execution %-10v instance %-30s %-20s %s",
+		e.Time.Local().Format("15:04:05"), e.ExecutionID, instance, status, e.Duration)
+	if e.Status == executionFailed {
+		line = highlight(line)
+	}
+	fmt.Println(line)
+}
+
+// printFailure shows the steps of a failed execution that failed, with their errors
+func (w *executionWatch) printFailure(id int) {
+	ex, _, err := fetchExecution(strconv.Itoa(id), w.profilemap["base"], w.profilemap["auth"])
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+	for _, s := range ex.StepExecutions {
+		if s.Status != executionFailed {
+			continue
+		}
+		if outputJSON {
+			stepbytes, _ := json.Marshal(s)
+			fmt.Printf("%s\n", stepbytes)
+			continue
+		}
+		fmt.Printf("    step %s failed:\n", s.StepName)
+		fmt.Printf("    %s\n", indentLines(s.stepError(), "    "))
+	}
+}
+
+func sortWatchEvents(events []watchEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		return events[i].ExecutionID < events[j].ExecutionID
+	})
+}
+
+// indentLines indents every line after the first
+func indentLines(text, indent string) string {
+	return strings.Replace(text, "\n", "\n"+indent, -1)
+}
+
+func init() {
+	formulaInstanceExecutionsCmd.AddCommand(watchExecutionsCmd)
+	watchExecutionsCmd.Flags().IntVar(&watchFormula, "formula", 0, "watch the Instances of this Formula")
+	watchExecutionsCmd.Flags().BoolVar(&watchAll, "all", false, "watch every Formula Instance")
+	watchExecutionsCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "how often to check for executions")
+	watchExecutionsCmd.Flags().BoolVarP(&watchDetails, "details", "x", false, "show the failing step's error when an execution fails")
+	watchExecutionsCmd.Flags().BoolVar(&watchExitOnFailure, "exit-on-failure", false, "exit non-zero at the first failed execution")
+	watchExecutionsCmd.Flags().IntVarP(&exportConcurrency, "concurrency", "r", 4, "max concurrent API calls")
+}
@@ -0,0 +1,103 @@
+package formula
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigValue is the configuration type of a plain value
+const ConfigValue = "value"
+
+// ElementInstanceID reads an Element Instance ID from a configuration or property
+// value, which may be a number, a numeric string or an object with an id
+func ElementInstanceID(v interface{}) (int, bool) {
+	switch t := v.(type) {
+	case float64:
+		return int(t), t == float64(int(t))
+	case int:
+		return t, true
+	case json.Number:
+		id, err := t.Int64()
+		return int(id), err == nil
+	case string:
+		id, err := strconv.Atoi(strings.TrimSpace(t))
+		return id, err == nil
+	case map[string]interface{}:
+		return ElementInstanceID(t["id"])
+	}
+	return 0, false
+}
+
+// ValidateConfiguration checks the configuration of a Formula Instance against the
+// Formula's declarations, returning every problem found: required values that are
+// missing, values of the wrong type, keys the Formula doesn't declare, and Element
+// Instances that instanceExists says aren't in the account.
+func ValidateConfiguration(f *Formula, values map[string]interface{}, instanceExists func(id int) bool) []string {
+	var problems []string
+	declared := f.ConfigKeys()
+
+	for _, c := range f.Configuration {
+		v, ok := values[c.Key]
+		if !ok || v == nil || v == "" {
+			if c.Required {
+				problems = append(problems, fmt.Sprintf("%s is required%s", c.Key, describeConfig(c)))
+			}
+			continue
+		}
+		switch c.Type {
+		case ConfigElementInstance:
+			id, ok := ElementInstanceID(v)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s must be an Element Instance ID, not %v", c.Key, v))
+			} else if instanceExists != nil && !instanceExists(id) {
+				problems = append(problems, fmt.Sprintf("%s is Element Instance %v, which doesn't exist", c.Key, id))
+			}
+		case ConfigValue:
+			switch v.(type) {
+			case map[string]interface{}, []interface{}:
+				problems = append(problems, fmt.Sprintf("%s must be a single value, not an object or list", c.Key))
+			}
+		}
+	}
+
+	var undeclared []string
+	for k := range values {
+		if _, ok := declared[k]; !ok {
+			undeclared = append(undeclared, k)
+		}
+	}
+	sort.Strings(undeclared)
+	for _, k := range undeclared {
+		problems = append(problems, fmt.Sprintf("%s isn't declared in the Formula configuration", k))
+	}
+	return problems
+}
+
+func describeConfig(c Configuration) string {
+	var parts []string
+	if c.Name != "" && c.Name != c.Key {
+		parts = append(parts, c.Name)
+	}
+	if c.Type != "" {
+		parts = append(parts, c.Type)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// DescribeConfiguration describes a configuration declaration for a prompt
+func DescribeConfiguration(c Configuration) string {
+	d := c.Key + describeConfig(c)
+	if c.Description != "" {
+		d += ": " + c.Description
+	}
+	if c.Required {
+		d += " [required]"
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+package formula
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kinds of Use
+const (
+	UseElementInstance = "elementInstance"
+	UseAPI             = "api"
+	UseSubFormula      = "subFormula"
+)
+
+// ConfigElementInstance is the configuration type of an Element Instance
+const ConfigElementInstance = "elementInstance"
+
+// Use is something outside a Formula that a trigger or step refers to
+type Use struct {
+	Kind string `json:"kind"`
+	// Step is the step, or trigger[n], that refers to it
+	Step string `json:"step"`
+	// Value is what's referred to: an Element Instance ID or ${config.key},
+	// an API path or URL, or a Formula ID
+	Value string `json:"value"`
+	// Method is the HTTP method of an API use
+	Method string `json:"method,omitempty"`
+}
+
+// Uses lists the Element Instances, APIs and sub-Formulas a Formula refers to
+func Uses(f *Formula) []Use {
+	var uses []Use
+	add := func(kind, step, value, method string) {
+		if value != "" {
+			uses = append(uses, Use{Kind: kind, Step: step, Value: value, Method: method})
+		}
+	}
+	for i, t := range f.Triggers {
+		for _, p := range elementReferences {
+			add(UseElementInstance, triggerLabel(i), propertyString(t.Properties, p), "")
+		}
+		add(UseAPI, triggerLabel(i), propertyString(t.Properties, "api"), propertyString(t.Properties, "method"))
+	}
+	for _, s := range f.Steps {
+		for _, p := range elementReferences {
+			add(UseElementInstance, s.Name, propertyString(s.Properties, p), "")
+		}
+		switch s.Type {
+		case StepElementRequest, StepElementRequestStream:
+			add(UseAPI, s.Name, propertyString(s.Properties, "api"), propertyString(s.Properties, "method"))
+		case StepHTTPRequest:
+			add(UseAPI, s.Name, propertyString(s.Properties, "url"), propertyString(s.Properties, "method"))
+		case StepSubFormula:
+			add(UseSubFormula, s.Name, propertyString(s.Properties, "formulaId"), "")
+		}
+	}
+	return uses
+}
+
+// ElementInstanceConfigKeys returns the configuration keys that hold an Element Instance
+func (f *Formula) ElementInstanceConfigKeys() []string {
+	var keys []string
+	for _, c := range f.Configuration {
+		if c.Type == ConfigElementInstance {
+			keys = append(keys, c.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ConfigKeyOf returns the configuration key of a ${config.key} value
+func ConfigKeyOf(value string) (string, bool) {
+	v := strings.TrimSpace(value)
+	if !strings.HasPrefix(v, "${") || !strings.HasSuffix(v, "}") {
+		return "", false
+	}
+	m := configExpression.FindStringSubmatch(strings.TrimSpace(v[2 : len(v)-1]))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// PathSegments returns the literal segments of an API path or URL, without
+// query, scheme, host, ${...} expressions or {param} placeholders
+func PathSegments(api string) []string {
+	if i := strings.IndexAny(api, "?#"); i >= 0 {
+		api = api[:i]
+	}
+	if i := strings.Index(api, "://"); i >= 0 {
+		api = api[i+3:]
+		if j := strings.Index(api, "/"); j >= 0 {
+			api = api[j:]
+		} else {
+			api = ""
+		}
+	}
+	var segments []string
+	for _, s := range strings.Split(api, "/") {
+		if s == "" || strings.Contains(s, "${") || strings.HasPrefix(s, "{") || strings.HasPrefix(s, ":") {
+			continue
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
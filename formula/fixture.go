@@ -0,0 +1,172 @@
+package formula
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FixtureSuffix names the files that hold Formula test fixtures
+const FixtureSuffix = ".test.json"
+
+// Fixture is a Formula test: a trigger and stubbed responses to simulate the
+// Formula with, and what the execution is expected to do
+type Fixture struct {
+	Name string `json:"name"`
+	// Formula is the path of the Formula JSON file or unpacked directory, relative to the fixture
+	Formula string                 `json:"formula"`
+	Trigger interface{}            `json:"trigger"`
+	Config  map[string]interface{} `json:"config"`
+	Stubs   Stubs                  `json:"stubs"`
+	Expect  Expectations           `json:"expect"`
+
+	// File is the fixture's path
+	File string `json:"-"`
+}
+
+// Expectations are the assertions of a Fixture. Each is only checked if given.
+type Expectations struct {
+	// Status is success or failed
+	Status string `json:"status"`
+	// Path is every step that runs, in order
+	Path []string `json:"path"`
+	// Visits are steps that must run, and NotVisits steps that mustn't
+	Visits    []string `json:"visits"`
+	NotVisits []string `json:"notVisits"`
+	// Steps are step values; objects only need to contain the fields given
+	Steps map[string]interface{} `json:"steps"`
+}
+
+// LoadFixture reads a fixture file
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fx Fixture
+	if err := decode(data, &fx); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	fx.File = path
+	if fx.Name == "" {
+		fx.Name = strings.TrimSuffix(filepath.Base(path), FixtureSuffix)
+	}
+	return &fx, nil
+}
+
+// FormulaPath is the path of the fixture's Formula
+func (fx *Fixture) FormulaPath() string {
+	if filepath.IsAbs(fx.Formula) {
+		return fx.Formula
+	}
+	return filepath.Join(filepath.Dir(fx.File), fx.Formula)
+}
+
+// Check compares an execution with the fixture's expectations and returns what didn't match
+func (fx *Fixture) Check(exec *Execution) []string {
+	var failures []string
+	e := fx.Expect
+
+	if e.Status != "" && e.Status != exec.Status {
+		failures = append(failures, fmt.Sprintf("status is %s, expected %s%s", exec.Status, e.Status, describeErrors(exec.Errors)))
+	}
+	if e.Path != nil && !reflect.DeepEqual(e.Path, exec.Path) {
+		failures = append(failures, fmt.Sprintf("path is %s, expected %s", strings.Join(exec.Path, " > "), strings.Join(e.Path, " > ")))
+	}
+	visited := make(map[string]bool)
+	for _, s := range exec.Path {
+		visited[s] = true
+	}
+	for _, s := range e.Visits {
+		if !visited[s] {
+			failures = append(failures, fmt.Sprintf("step %s didn't run", s))
+		}
+	}
+	for _, s := range e.NotVisits {
+		if visited[s] {
+			failures = append(failures, fmt.Sprintf("step %s ran", s))
+		}
+	}
+
+	names := make([]string, 0, len(e.Steps))
+	for name := range e.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		actual, ok := exec.Steps[name]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("step %s has no value, it didn't run", name))
+			continue
+		}
+		if path, ok := matches(e.Steps[name], actual, ""); !ok {
+			failures = append(failures, fmt.Sprintf("steps.%s%s is %s, expected %s",
+				name, path, jsonText(lookupPath(actual, strings.TrimPrefix(path, "."))), jsonText(lookupPath(e.Steps[name], strings.TrimPrefix(path, ".")))))
+		}
+	}
+	return failures
+}
+
+// matches reports whether actual has everything in expected, returning the path of the first difference
+func matches(expected, actual interface{}, path string) (string, bool) {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			return path, false
+		}
+		keys := make([]string, 0, len(e))
+		for k := range e {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := matches(e[k], a[k], path+"."+k); !ok {
+				return p, false
+			}
+		}
+		return path, true
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok || len(a) != len(e) {
+			return path, false
+		}
+		for i := range e {
+			if p, ok := matches(e[i], a[i], fmt.Sprintf("%s.%v", path, i)); !ok {
+				return p, false
+			}
+		}
+		return path, true
+	}
+	return path, jsonText(expected) == jsonText(actual)
+}
+
+// jsonText writes a value as JSON, so numbers compare the same however they were decoded
+func jsonText(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			v = f
+		}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+func describeErrors(errs map[string]string) string {
+	if len(errs) == 0 {
+		return ""
+	}
+	var parts []string
+	for step, err := range errs {
+		parts = append(parts, step+": "+err)
+	}
+	sort.Strings(parts)
+	return " (" + strings.Join(parts, "; ") + ")"
+}
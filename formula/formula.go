@@ -0,0 +1,195 @@
+// Package formula works with Formula templates offline: loading them from
+// files, and inspecting their triggers, steps and configuration.
+package formula
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Trigger types
+const (
+	TriggerEvent          = "event"
+	TriggerScheduled      = "scheduled"
+	TriggerManual         = "manual"
+	TriggerElementRequest = "elementRequest"
+)
+
+// Step types
+const (
+	StepScript               = "script"
+	StepFilter               = "filter"
+	StepElementRequest       = "elementRequest"
+	StepElementRequestStream = "elementRequestStream"
+	StepHTTPRequest          = "httpRequest"
+	StepLoop                 = "loop"
+	StepSubFormula           = "formula"
+	StepNotification         = "notification"
+	StepRetry                = "retryFormulaExecution"
+)
+
+// Formula is a Formula template as represented in Formula JSON
+type Formula struct {
+	ID             int             `json:"id,omitempty"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description,omitempty"`
+	Active         bool            `json:"active"`
+	SingleThreaded bool            `json:"singleThreaded,omitempty"`
+	Engine         string          `json:"engine,omitempty"`
+	CreatedDate    string          `json:"createdDate,omitempty"`
+	UpdatedDate    string          `json:"updatedDate,omitempty"`
+	Configuration  []Configuration `json:"configuration,omitempty"`
+	Triggers       []Trigger       `json:"triggers"`
+	Steps          []Step          `json:"steps"`
+}
+
+// Configuration is a value declared by a Formula and supplied by each Formula Instance
+type Configuration struct {
+	ID          int    `json:"id,omitempty"`
+	Key         string `json:"key"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+}
+
+// Trigger starts a Formula execution
+type Trigger struct {
+	ID         int                    `json:"id,omitempty"`
+	Type       string                 `json:"type"`
+	Name       string                 `json:"name,omitempty"`
+	Async      bool                   `json:"async"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	OnSuccess  []string               `json:"onSuccess"`
+	OnFailure  []string               `json:"onFailure"`
+}
+
+// Step is a single step of a Formula
+type Step struct {
+	ID         int                    `json:"id,omitempty"`
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+	OnSuccess  []string               `json:"onSuccess"`
+	OnFailure  []string               `json:"onFailure"`
+}
+
+// Parse decodes Formula JSON
+func Parse(data []byte) (*Formula, error) {
+	var f Formula
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Load reads a Formula JSON file
+func Load(path string) (*Formula, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// LooksLikeFormula reports whether JSON data is an object with steps or triggers,
+// used to pick Formula files out of a directory
+func LooksLikeFormula(data []byte) bool {
+	var probe map[string]json.RawMessage
+	if json.Unmarshal(data, &probe) != nil {
+		return false
+	}
+	_, steps := probe["steps"]
+	_, triggers := probe["triggers"]
+	return steps || triggers
+}
+
+// Step returns the step with the given name
+func (f *Formula) Step(name string) (*Step, bool) {
+	for i := range f.Steps {
+		if f.Steps[i].Name == name {
+			return &f.Steps[i], true
+		}
+	}
+	return nil, false
+}
+
+// ConfigKeys returns the declared configuration keys
+func (f *Formula) ConfigKeys() map[string]Configuration {
+	keys := make(map[string]Configuration)
+	for _, c := range f.Configuration {
+		keys[c.Key] = c
+	}
+	return keys
+}
+
+// Script returns the JavaScript body of a script or filter step
+func (s Step) Script() (string, bool) {
+	if s.Type != StepScript && s.Type != StepFilter {
+		return "", false
+	}
+	body, ok := s.Properties["body"].(string)
+	return body, ok
+}
+
+// StringProperty returns a string property of a step, or "" if it isn't set
+func (s Step) StringProperty(name string) string {
+	v, _ := s.Properties[name].(string)
+	return v
+}
+
+var expressionPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Expressions returns every ${...} expression found in string values of v,
+// such as step or trigger properties, without the surrounding ${ }
+func Expressions(v interface{}) []string {
+	var found []string
+	walkStrings(v, func(s string) {
+		for _, m := range expressionPattern.FindAllStringSubmatch(s, -1) {
+			found = append(found, strings.TrimSpace(m[1]))
+		}
+	})
+	return found
+}
+
+// walkStrings calls fn for every string value in a decoded JSON value
+func walkStrings(v interface{}, fn func(string)) {
+	switch t := v.(type) {
+	case string:
+		fn(t)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkStrings(t[k], fn)
+		}
+	case []interface{}:
+		for _, child := range t {
+			walkStrings(child, fn)
+		}
+	}
+}
+
+// NonScriptProperties returns a step's properties without its script body,
+// so expressions can be looked for without matching JavaScript
+func (s Step) NonScriptProperties() map[string]interface{} {
+	props := make(map[string]interface{})
+	for k, v := range s.Properties {
+		if k == "body" {
+			if _, ok := s.Script(); ok {
+				continue
+			}
+		}
+		props[k] = v
+	}
+	return props
+}
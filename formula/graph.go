@@ -0,0 +1,208 @@
+package formula
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Graph formats
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// graphNode is a trigger or step drawn in a graph
+type graphNode struct {
+	ID      string
+	Lines   []string
+	Trigger bool
+	Missing bool
+}
+
+// graphEdge is an onSuccess or onFailure transition
+type graphEdge struct {
+	From, To string
+	Failure  bool
+}
+
+// elementReferences are the properties that point a trigger or step at an Element Instance
+var elementReferences = []string{"elementInstanceId", "elementInstance"}
+
+// graph lays out a Formula's triggers and steps as nodes, and their transitions as edges.
+// Targets that aren't steps of the Formula get a node of their own, marked missing.
+func graph(f *Formula) ([]graphNode, []graphEdge) {
+	var nodes []graphNode
+	var edges []graphEdge
+
+	ids := make(map[string]string)
+	for i, s := range f.Steps {
+		if _, ok := ids[s.Name]; ok {
+			continue
+		}
+		ids[s.Name] = fmt.Sprintf("step%v", i)
+		lines := []string{s.Name, s.Type}
+		lines = append(lines, references(s.Properties)...)
+		if s.Type == StepSubFormula {
+			if id := propertyString(s.Properties, "formulaId"); id != "" {
+				lines = append(lines, "formula "+id)
+			}
+		}
+		nodes = append(nodes, graphNode{ID: ids[s.Name], Lines: lines})
+	}
+
+	target := func(name string) string {
+		if id, ok := ids[name]; ok {
+			return id
+		}
+		id := fmt.Sprintf("missing%v", len(ids))
+		ids[name] = id
+		nodes = append(nodes, graphNode{ID: id, Lines: []string{name, "not a step"}, Missing: true})
+		return id
+	}
+	connect := func(from string, onSuccess, onFailure []string) {
+		for _, t := range onSuccess {
+			edges = append(edges, graphEdge{From: from, To: target(t)})
+		}
+		for _, t := range onFailure {
+			edges = append(edges, graphEdge{From: from, To: target(t), Failure: true})
+		}
+	}
+
+	var triggers []graphNode
+	for i, t := range f.Triggers {
+		id := fmt.Sprintf("trigger%v", i)
+		lines := []string{"trigger", t.Type}
+		lines = append(lines, references(t.Properties)...)
+		if cron := propertyString(t.Properties, "cron"); cron != "" {
+			lines = append(lines, cron)
+		}
+		triggers = append(triggers, graphNode{ID: id, Lines: lines, Trigger: true})
+		connect(id, t.OnSuccess, t.OnFailure)
+	}
+	for _, s := range f.Steps {
+		if ids[s.Name] == "" {
+			continue
+		}
+		connect(ids[s.Name], s.OnSuccess, s.OnFailure)
+	}
+
+	return append(triggers, nodes...), edges
+}
+
+// references returns the Element Instance references among a trigger or step's properties
+func references(props map[string]interface{}) []string {
+	var refs []string
+	for _, p := range elementReferences {
+		if v := propertyString(props, p); v != "" {
+			refs = append(refs, "instance "+v)
+		}
+	}
+	return refs
+}
+
+// propertyString returns a property as a string, formatting numbers, or "" if it isn't set
+func propertyString(props map[string]interface{}, name string) string {
+	v, ok := props[name]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// DOT renders a Formula as a Graphviz digraph
+func DOT(f *Formula) string {
+	nodes, edges := graph(f)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(f.Name))
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, n := range nodes {
+		attrs := fmt.Sprintf("label=%s", dotQuote(strings.Join(n.Lines, "\n")))
+		switch {
+		case n.Trigger:
+			attrs += ", shape=ellipse, style=filled, fillcolor=\"#dae8fc\""
+		case n.Missing:
+			attrs += ", style=\"rounded,dashed\", color=\"#999999\", fontcolor=\"#999999\""
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", n.ID, attrs)
+	}
+	for _, e := range edges {
+		if e.Failure {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"onFailure\", color=\"#b85450\", fontcolor=\"#b85450\", style=dashed];\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s [label=\"onSuccess\", color=\"#82b366\", fontcolor=\"#82b366\"];\n", e.From, e.To)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// Mermaid renders a Formula as a Mermaid flowchart
+func Mermaid(f *Formula) string {
+	nodes, edges := graph(f)
+
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, n := range nodes {
+		label := mermaidQuote(n.Lines)
+		switch {
+		case n.Trigger:
+			fmt.Fprintf(&b, "  %s([%s])\n", n.ID, label)
+		default:
+			fmt.Fprintf(&b, "  %s[%s]\n", n.ID, label)
+		}
+	}
+	for _, e := range edges {
+		if e.Failure {
+			fmt.Fprintf(&b, "  %s -. onFailure .-> %s\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(&b, "  %s -- onSuccess --> %s\n", e.From, e.To)
+		}
+	}
+
+	// failure edges are drawn red, in the order they were added
+	var failures []string
+	for i, e := range edges {
+		if e.Failure {
+			failures = append(failures, fmt.Sprintf("%v", i))
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(&b, "  linkStyle %s stroke:#b85450,color:#b85450\n", strings.Join(failures, ","))
+	}
+	b.WriteString("  classDef trigger fill:#dae8fc,stroke:#6c8ebf\n")
+	b.WriteString("  classDef missing stroke-dasharray:4 4,color:#999999\n")
+	for _, n := range nodes {
+		if n.Trigger {
+			fmt.Fprintf(&b, "  class %s trigger\n", n.ID)
+		} else if n.Missing {
+			fmt.Fprintf(&b, "  class %s missing\n", n.ID)
+		}
+	}
+	return b.String()
+}
+
+func mermaidQuote(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, l := range lines {
+		l = strings.Replace(l, `"`, "#quot;", -1)
+		escaped[i] = l
+	}
+	return `"` + strings.Join(escaped, "<br/>") + `"`
+}
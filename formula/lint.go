@@ -0,0 +1,273 @@
+package formula
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/parser"
+)
+
+// scriptParameters are the values the platform makes available to script steps
+const scriptParameters = "trigger, steps, info, config, done"
+
+// Diagnostic is a problem found in a Formula
+type Diagnostic struct {
+	File    string
+	Step    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%s:%s", d.File, d.Step, d.Message)
+}
+
+var configExpression = regexp.MustCompile(`^config\.(.+)$`)
+
+// Lint checks a Formula for problems that would otherwise only show up when it runs.
+// file is used to label the diagnostics.
+func Lint(file string, f *Formula) []Diagnostic {
+	var diags []Diagnostic
+	report := func(step, format string, a ...interface{}) {
+		diags = append(diags, Diagnostic{File: file, Step: step, Message: fmt.Sprintf(format, a...)})
+	}
+
+	// duplicate steps
+	seen := make(map[string]int)
+	for _, s := range f.Steps {
+		seen[s.Name]++
+		if seen[s.Name] == 2 {
+			report(s.Name, "duplicate step name")
+		}
+		if s.Name == "" {
+			report("", "step without a name")
+		}
+	}
+
+	// references to missing steps
+	checkTargets := func(from, kind string, targets []string) {
+		for _, t := range targets {
+			if _, ok := seen[t]; !ok {
+				report(from, "%s references missing step %s", kind, t)
+			}
+		}
+	}
+	for i, t := range f.Triggers {
+		checkTargets(triggerLabel(i), "onSuccess", t.OnSuccess)
+		checkTargets(triggerLabel(i), "onFailure", t.OnFailure)
+	}
+	for _, s := range f.Steps {
+		checkTargets(s.Name, "onSuccess", s.OnSuccess)
+		checkTargets(s.Name, "onFailure", s.OnFailure)
+	}
+
+	// unreachable steps
+	reachable := Reachable(f)
+	for _, s := range f.Steps {
+		if s.Name != "" && !reachable[s.Name] {
+			report(s.Name, "unreachable step")
+		}
+	}
+
+	// triggers
+	if len(f.Triggers) == 0 {
+		report("formula", "no trigger")
+	}
+	for i, t := range f.Triggers {
+		for _, p := range requiredTriggerProperties[t.Type] {
+			if v, ok := t.Properties[p]; !ok || v == "" {
+				report(triggerLabel(i), "%s trigger missing %s", t.Type, p)
+			}
+		}
+		if t.Type == "" {
+			report(triggerLabel(i), "trigger without a type")
+		}
+	}
+
+	// configuration references
+	declared := f.ConfigKeys()
+	for i, t := range f.Triggers {
+		for _, key := range configReferences(t.Properties) {
+			if _, ok := declared[key]; !ok {
+				report(triggerLabel(i), "${config.%s} is not declared in the formula configuration", key)
+			}
+		}
+	}
+	for _, s := range f.Steps {
+		for _, key := range configReferences(s.NonScriptProperties()) {
+			if _, ok := declared[key]; !ok {
+				report(s.Name, "${config.%s} is not declared in the formula configuration", key)
+			}
+		}
+		if body, ok := s.Script(); ok {
+			for _, key := range scriptConfigReferences(body) {
+				if !declaredPrefix(declared, key) {
+					report(s.Name, "config.%s is not declared in the formula configuration", key)
+				}
+			}
+		}
+	}
+
+	// script syntax
+	for _, s := range f.Steps {
+		body, ok := s.Script()
+		if !ok {
+			if s.Type == StepScript || s.Type == StepFilter {
+				report(s.Name, "%s step without a body", s.Type)
+			}
+			continue
+		}
+		if err := CheckScript(body); err != nil {
+			report(s.Name, "script syntax error: %s", err.Error())
+		}
+	}
+
+	return diags
+}
+
+// requiredTriggerProperties are the properties each trigger type needs to be configured
+var requiredTriggerProperties = map[string][]string{
+	TriggerEvent:          {"elementInstanceId"},
+	TriggerScheduled:      {"cron"},
+	TriggerElementRequest: {"elementInstanceId", "method", "api"},
+}
+
+func triggerLabel(i int) string {
+	return fmt.Sprintf("trigger[%v]", i)
+}
+
+// CheckScript parses a script step body as the platform would run it, as the
+// body of a function of the script parameters. Errors give the line within the body.
+func CheckScript(body string) error {
+	_, err := parser.ParseFunction(scriptParameters, body)
+	if err == nil {
+		return nil
+	}
+	if list, ok := err.(parser.ErrorList); ok && len(list) > 0 {
+		// the body starts on the second line of the function ParseFunction wraps it in
+		return fmt.Errorf("line %v:%v %s", list[0].Position.Line-1, list[0].Position.Column, list[0].Message)
+	}
+	return fmt.Errorf("%s", strings.Replace(err.Error(), "\n", " ", -1))
+}
+
+// Reachable returns the names of steps that can be reached from a trigger
+func Reachable(f *Formula) map[string]bool {
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, t := range f.Triggers {
+		queue = append(queue, t.OnSuccess...)
+		queue = append(queue, t.OnFailure...)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if reachable[name] {
+			continue
+		}
+		reachable[name] = true
+		if s, ok := f.Step(name); ok {
+			queue = append(queue, s.OnSuccess...)
+			queue = append(queue, s.OnFailure...)
+		}
+	}
+	return reachable
+}
+
+// configReferences returns the keys of ${config.key} expressions in v
+func configReferences(v interface{}) []string {
+	var keys []string
+	for _, e := range Expressions(v) {
+		if m := configExpression.FindStringSubmatch(e); m != nil {
+			keys = append(keys, m[1])
+		}
+	}
+	return unique(keys)
+}
+
+// scriptConfigReferences returns the names used as config.name or config['name']
+// in a script, where config is the script parameter rather than a member of
+// something else. Comments and strings don't count; a script that doesn't parse
+// has none.
+func scriptConfigReferences(body string) []string {
+	fn, err := parser.ParseFunction(scriptParameters, body)
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	walkNodes(reflect.ValueOf(fn), func(n interface{}) {
+		switch e := n.(type) {
+		case *ast.DotExpression:
+			if isConfig(e.Left) {
+				keys = append(keys, string(e.Identifier.Name))
+			}
+		case *ast.BracketExpression:
+			if member, ok := e.Member.(*ast.StringLiteral); ok && isConfig(e.Left) {
+				keys = append(keys, string(member.Value))
+			}
+		}
+	})
+	return unique(keys)
+}
+
+// isConfig reports whether an expression is the config parameter itself
+func isConfig(e ast.Expression) bool {
+	id, ok := e.(*ast.Identifier)
+	return ok && id.Name == "config"
+}
+
+// walkNodes calls visit with every pointer reachable from v, which for a parsed
+// script is each node of its syntax tree
+func walkNodes(v reflect.Value, visit func(interface{})) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		visit(v.Interface())
+		walkNodes(v.Elem(), visit)
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkNodes(v.Elem(), visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkNodes(v.Index(i), visit)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" { // exported
+				walkNodes(v.Field(i), visit)
+			}
+		}
+	}
+}
+
+// declaredPrefix reports whether key is declared, allowing for dotted keys
+// (config.crm.instance in a script is config["crm.instance"] on the platform)
+func declaredPrefix(declared map[string]Configuration, key string) bool {
+	if _, ok := declared[key]; ok {
+		return true
+	}
+	for k := range declared {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
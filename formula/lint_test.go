@@ -0,0 +1,119 @@
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula string
+		want    []string
+	}{
+		{
+			name: "clean",
+			formula: `{
+  "configuration": [{"key": "crm.instance", "type": "elementInstance"}, {"key": "limit", "type": "value"}],
+  "triggers": [{"type": "event", "properties": {"elementInstanceId": "${config.crm.instance}"}, "onSuccess": ["build"]}],
+  "steps": [{"name": "build", "type": "script", "properties": {"body": "done({limit: config.limit, crm: config.crm.instance, other: config['limit']});"}}]
+}`,
+		},
+		{
+			name: "duplicate and unnamed steps",
+			formula: `{
+  "triggers": [{"type": "manual", "onSuccess": ["a"]}],
+  "steps": [
+    {"name": "a", "type": "script", "properties": {"body": "done();"}, "onSuccess": ["a"]},
+    {"name": "a", "type": "script", "properties": {"body": "done();"}},
+    {"name": "", "type": "script", "properties": {"body": "done();"}}
+  ]
+}`,
+			want: []string{"f:a:duplicate step name", "f::step without a name"},
+		},
+		{
+			name: "missing targets and unreachable steps",
+			formula: `{
+  "triggers": [{"type": "manual", "onSuccess": ["a"], "onFailure": ["gone"]}],
+  "steps": [
+    {"name": "a", "type": "script", "properties": {"body": "done();"}, "onFailure": ["missing"]},
+    {"name": "island", "type": "script", "properties": {"body": "done();"}}
+  ]
+}`,
+			want: []string{
+				"f:trigger[0]:onFailure references missing step gone",
+				"f:a:onFailure references missing step missing",
+				"f:island:unreachable step",
+			},
+		},
+		{
+			name:    "no trigger",
+			formula: `{"steps": []}`,
+			want:    []string{"f:formula:no trigger"},
+		},
+		{
+			name: "trigger properties and type",
+			formula: `{
+  "triggers": [{"type": "scheduled", "onSuccess": ["a"]}, {"onSuccess": ["a"]}],
+  "steps": [{"name": "a", "type": "script", "properties": {"body": "done();"}}]
+}`,
+			want: []string{"f:trigger[0]:scheduled trigger missing cron", "f:trigger[1]:trigger without a type"},
+		},
+		{
+			name: "undeclared configuration expressions",
+			formula: `{
+  "triggers": [{"type": "event", "properties": {"elementInstanceId": "${config.crm}"}, "onSuccess": ["get"]}],
+  "steps": [{"name": "get", "type": "elementRequest", "properties": {"elementInstanceId": "${config.erp}", "method": "GET", "api": "/contacts"}}]
+}`,
+			want: []string{
+				"f:trigger[0]:${config.crm} is not declared in the formula configuration",
+				"f:get:${config.erp} is not declared in the formula configuration",
+			},
+		},
+		{
+			name: "undeclared configuration in a script",
+			formula: `{
+  "triggers": [{"type": "manual", "onSuccess": ["a"]}],
+  "steps": [{"name": "a", "type": "script", "properties": {"body": "done({x: config.missing, y: config[\"other\"]});"}}]
+}`,
+			want: []string{
+				"f:a:config.missing is not declared in the formula configuration",
+				"f:a:config.other is not declared in the formula configuration",
+			},
+		},
+		{
+			name: "config as a member, in comments and in strings",
+			formula: `{
+  "triggers": [{"type": "manual", "onSuccess": ["a"]}],
+  "steps": [{"name": "a", "type": "script", "properties": {"body": "// config.notes\n/* config.block */\nvar t = steps.getIt.response.config.timeout;\nvar s = 'config.other' + \"config['quoted']\";\ndone({t: t, s: s, u: info.config['x']});"}}]
+}`,
+		},
+		{
+			name: "script problems",
+			formula: `{
+  "triggers": [{"type": "manual", "onSuccess": ["a"]}],
+  "steps": [
+    {"name": "a", "type": "script", "properties": {"body": "var x = ;\ndone();"}, "onSuccess": ["b"]},
+    {"name": "b", "type": "filter", "properties": {}}
+  ]
+}`,
+			want: []string{"f:a:script syntax error: line 1:9 Unexpected token ;", "f:b:filter step without a body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.formula))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range Lint("f", f) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint() =\n  %q\nwant\n  %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package formula
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// ScriptTimeout bounds how long a script step may run before it's interrupted
+var ScriptTimeout = 30 * time.Second
+
+// StepContext is what the platform makes available to a step as it runs
+type StepContext struct {
+	Trigger interface{}            `json:"trigger"`
+	Steps   map[string]interface{} `json:"steps"`
+	Config  map[string]interface{} `json:"config"`
+	Info    map[string]interface{} `json:"info"`
+}
+
+// StepResult is the outcome of running a step
+type StepResult struct {
+	// Value is the step's value, as later steps see it in steps.<name>
+	Value interface{} `json:"value"`
+	// Success is whether the execution carries on with onSuccess rather than onFailure
+	Success bool `json:"success"`
+	// Error is the error the step threw or failed with, if any
+	Error string `json:"error,omitempty"`
+	// Logs are the lines the step wrote with console.log
+	Logs []string `json:"logs,omitempty"`
+}
+
+// RunScript runs a script or filter step with the platform's contract: the body is a
+// function of trigger, steps, info, config and done, and its value is whatever it
+// passes to done(). A filter passes true or false, choosing onSuccess or onFailure.
+// A thrown error fails the step. An error is returned only if the step can't be run at all.
+func RunScript(s Step, ctx StepContext) (*StepResult, error) {
+	body, ok := s.Script()
+	if !ok {
+		return nil, fmt.Errorf("step %s is a %s step, not a script or filter", s.Name, s.Type)
+	}
+
+	vm := goja.New()
+	result := &StepResult{}
+
+	console := vm.NewObject()
+	console.Set("log", func(call goja.FunctionCall) goja.Value {
+		var parts []string
+		for _, a := range call.Arguments {
+			parts = append(parts, jsString(vm, a))
+		}
+		result.Logs = append(result.Logs, strings.Join(parts, " "))
+		return goja.Undefined()
+	})
+	vm.Set("console", console)
+
+	var doneValue goja.Value
+	doneCalled := false
+	done := func(call goja.FunctionCall) goja.Value {
+		if !doneCalled {
+			doneCalled = true
+			doneValue = call.Argument(0)
+		}
+		return goja.Undefined()
+	}
+
+	args := []goja.Value{}
+	for _, v := range []interface{}{ctx.Trigger, emptyIfNil(ctx.Steps), emptyIfNil(ctx.Info), emptyIfNil(ctx.Config)} {
+		jv, err := jsValue(vm, v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, jv)
+	}
+	args = append(args, vm.ToValue(done))
+
+	fnValue, err := vm.RunScript(s.Name, "(function ("+scriptParameters+") {\n"+body+"\n})")
+	if err != nil {
+		return nil, fmt.Errorf("script syntax error: %s", err.Error())
+	}
+	fn, ok := goja.AssertFunction(fnValue)
+	if !ok {
+		return nil, fmt.Errorf("step %s doesn't compile to a function", s.Name)
+	}
+
+	timer := time.AfterFunc(ScriptTimeout, func() {
+		vm.Interrupt(fmt.Sprintf("step ran for more than %s", ScriptTimeout))
+	})
+	returned, err := fn(goja.Undefined(), args...)
+	timer.Stop()
+	if err != nil {
+		result.Error = scriptError(vm, err)
+		return result, nil
+	}
+
+	// scripts written for the original engine return their value rather than calling done()
+	if !doneCalled && returned != nil && !goja.IsUndefined(returned) {
+		doneCalled = true
+		doneValue = returned
+	}
+	if !doneCalled {
+		result.Error = "step finished without calling done()"
+		return result, nil
+	}
+
+	if s.Type == StepFilter {
+		result.Success = doneValue != nil && doneValue.ToBoolean()
+		result.Value = map[string]interface{}{"continue": result.Success}
+		return result, nil
+	}
+	result.Success = true
+	result.Value, err = goValue(vm, doneValue)
+	if err != nil {
+		result.Success = false
+		result.Error = fmt.Sprintf("step value isn't JSON: %s", err.Error())
+	}
+	return result, nil
+}
+
+// jsValue copies a decoded JSON value into the runtime as native JavaScript values
+func jsValue(vm *goja.Runtime, v interface{}) (goja.Value, error) {
+	if v == nil {
+		return vm.NewObject(), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	parse, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("parse"))
+	return parse(goja.Undefined(), vm.ToValue(string(data)))
+}
+
+// goValue copies a JavaScript value out of the runtime as a decoded JSON value
+func goValue(vm *goja.Runtime, v goja.Value) (interface{}, error) {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil, nil
+	}
+	stringify, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("stringify"))
+	s, err := stringify(goja.Undefined(), v)
+	if err != nil {
+		return nil, err
+	}
+	if goja.IsUndefined(s) {
+		return nil, nil
+	}
+	var out interface{}
+	if err := decode([]byte(s.String()), &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// jsString formats a value as console.log would, objects other than errors as JSON
+func jsString(vm *goja.Runtime, v goja.Value) string {
+	if o, ok := v.(*goja.Object); ok && o.ClassName() != "Error" {
+		if out, err := goValue(vm, v); err == nil {
+			if data, err := json.Marshal(out); err == nil {
+				return string(data)
+			}
+		}
+	}
+	return v.String()
+}
+
+// thrownAt finds where an exception was thrown in the wrapped script
+var thrownAt = regexp.MustCompile(`at [^:\s]*:(\d+):(\d+)\(\d+\)`)
+
+// scriptError describes an error thrown by a script, with its line within the body
+func scriptError(vm *goja.Runtime, err error) string {
+	switch e := err.(type) {
+	case *goja.Exception:
+		msg := jsString(vm, e.Value())
+		if m := thrownAt.FindStringSubmatch(e.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			msg = fmt.Sprintf("%s (line %v:%s)", msg, line-1, m[2])
+		}
+		return msg
+	case *goja.InterruptedError:
+		return fmt.Sprintf("%v", e.Value())
+	}
+	return err.Error()
+}
+
+func emptyIfNil(m map[string]interface{}) interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
@@ -0,0 +1,285 @@
+package formula
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MaxSimulatedSteps stops a simulation that looks like it will never finish
+var MaxSimulatedSteps = 10000
+
+// Execution statuses of a simulation
+const (
+	ExecutionSuccess = "success"
+	ExecutionFailed  = "failed"
+)
+
+// Stub is a canned response for an elementRequest, httpRequest or other step
+// that would call out of the Formula
+type Stub struct {
+	Status  int                    `json:"status"`
+	Body    interface{}            `json:"body"`
+	Headers map[string]interface{} `json:"headers,omitempty"`
+}
+
+// Stubs are the responses for each step by name. A step called more than once,
+// such as inside a loop, is given its responses in turn, the last one repeating.
+type Stubs map[string][]Stub
+
+// UnmarshalJSON accepts a single response or a list of responses for each step
+func (s *Stubs) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = make(Stubs)
+	for name, r := range raw {
+		var list []Stub
+		if err := decode(r, &list); err != nil {
+			var one Stub
+			if err := decode(r, &one); err != nil {
+				return fmt.Errorf("stub for %s: %s", name, err.Error())
+			}
+			list = []Stub{one}
+		}
+		(*s)[name] = list
+	}
+	return nil
+}
+
+// Execution is the outcome of simulating a Formula
+type Execution struct {
+	Status string
+	// Path lists the steps in the order they ran; steps in loops appear once per run
+	Path []string
+	// Steps are the values of the steps that ran, as the last run left them
+	Steps map[string]interface{}
+	// Errors are the errors of the steps that failed
+	Errors map[string]string
+	// Logs are the lines written with console.log, prefixed with the step name
+	Logs []string
+}
+
+// Simulate runs a Formula offline from its first trigger: scripts and filters run
+// in the embedded JavaScript engine, steps that call out are answered from stubs,
+// and loops go round their list. Steps without a stub that don't call out succeed
+// with an empty value.
+func Simulate(f *Formula, trigger interface{}, config map[string]interface{}, stubs Stubs) (*Execution, error) {
+	if len(f.Triggers) == 0 {
+		return nil, fmt.Errorf("formula has no trigger")
+	}
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	sim := &simulation{
+		formula: f,
+		stubs:   stubs,
+		calls:   make(map[string]int),
+		loops:   make(map[string]int),
+		ctx: StepContext{
+			Trigger: trigger,
+			Steps:   make(map[string]interface{}),
+			Config:  config,
+			Info:    map[string]interface{}{"formulaId": f.ID, "formulaInstanceId": 0, "executionId": 0},
+		},
+		exec: &Execution{Status: ExecutionSuccess, Errors: make(map[string]string)},
+	}
+
+	if err := sim.next(f.Triggers[0].OnSuccess); err != nil {
+		return nil, err
+	}
+	sim.exec.Steps = sim.ctx.Steps
+	return sim.exec, nil
+}
+
+type simulation struct {
+	formula *Formula
+	stubs   Stubs
+	calls   map[string]int
+	loops   map[string]int
+	ctx     StepContext
+	exec    *Execution
+	ran     int
+}
+
+// next runs each of the named steps, and the steps that follow them, in turn
+func (sim *simulation) next(names []string) error {
+	for _, name := range names {
+		if err := sim.run(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sim *simulation) run(name string) error {
+	sim.ran++
+	if sim.ran > MaxSimulatedSteps {
+		return fmt.Errorf("stopped after %v steps, the formula may never finish", MaxSimulatedSteps)
+	}
+	step, ok := sim.formula.Step(name)
+	if !ok {
+		return fmt.Errorf("step %s doesn't exist", name)
+	}
+	sim.exec.Path = append(sim.exec.Path, name)
+
+	result := sim.step(step)
+	for _, l := range result.Logs {
+		sim.exec.Logs = append(sim.exec.Logs, name+": "+l)
+	}
+	if result.Value != nil || step.Type != StepLoop {
+		sim.ctx.Steps[name] = result.Value
+	}
+	if result.Success {
+		return sim.next(step.OnSuccess)
+	}
+	// a filter that's false or a loop that has run out carries on with onFailure,
+	// or stops there, which isn't a failure
+	if result.Error != "" {
+		sim.exec.Errors[name] = result.Error
+		if len(step.OnFailure) == 0 {
+			sim.exec.Status = ExecutionFailed
+		}
+	}
+	return sim.next(step.OnFailure)
+}
+
+// step works out the result of one run of a step
+func (sim *simulation) step(s *Step) *StepResult {
+	switch s.Type {
+	case StepScript, StepFilter:
+		result, err := RunScript(*s, sim.ctx)
+		if err != nil {
+			return &StepResult{Error: err.Error()}
+		}
+		return result
+	case StepLoop:
+		return sim.loop(s)
+	}
+
+	stub, ok := sim.stub(s.Name)
+	switch {
+	case ok:
+		// a status of 0 in a stub is taken as 200
+		if stub.Status == 0 {
+			stub.Status = 200
+		}
+		value := map[string]interface{}{
+			"request":  sim.resolve(s.Properties),
+			"response": map[string]interface{}{"code": stub.Status, "body": stub.Body, "headers": stub.Headers},
+		}
+		if stub.Status < 200 || stub.Status > 299 {
+			return &StepResult{Value: value, Error: fmt.Sprintf("HTTP %v", stub.Status)}
+		}
+		return &StepResult{Value: value, Success: true}
+	case s.Type == StepElementRequest || s.Type == StepElementRequestStream || s.Type == StepHTTPRequest:
+		return &StepResult{Error: "no stubbed response"}
+	}
+	return &StepResult{Value: map[string]interface{}{}, Success: true}
+}
+
+// stub returns the next stubbed response for a step
+func (sim *simulation) stub(name string) (Stub, bool) {
+	list := sim.stubs[name]
+	if len(list) == 0 {
+		return Stub{}, false
+	}
+	i := sim.calls[name]
+	sim.calls[name]++
+	if i >= len(list) {
+		i = len(list) - 1
+	}
+	return list[i], true
+}
+
+// loop gives the next entry of a loop's list, or fails once the list is done
+func (sim *simulation) loop(s *Step) *StepResult {
+	list, _ := sim.resolve(s.Properties["list"]).([]interface{})
+	i := sim.loops[s.Name]
+	if i >= len(list) {
+		delete(sim.loops, s.Name)
+		return &StepResult{}
+	}
+	sim.loops[s.Name] = i + 1
+	return &StepResult{Value: map[string]interface{}{"index": i, "entry": list[i]}, Success: true}
+}
+
+// resolve replaces ${...} expressions in a property with values from the execution.
+// A string that's a single expression becomes the value itself, whatever its type.
+func (sim *simulation) resolve(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		if m := expressionPattern.FindStringSubmatchIndex(t); m != nil && m[0] == 0 && m[1] == len(t) {
+			return sim.lookup(strings.TrimSpace(t[m[2]:m[3]]))
+		}
+		return expressionPattern.ReplaceAllStringFunc(t, func(e string) string {
+			value := sim.lookup(strings.TrimSpace(e[2 : len(e)-1]))
+			if s, ok := value.(string); ok {
+				return s
+			}
+			data, _ := json.Marshal(value)
+			return string(data)
+		})
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, child := range t {
+			out[k] = sim.resolve(child)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, child := range t {
+			out[i] = sim.resolve(child)
+		}
+		return out
+	}
+	return v
+}
+
+// lookup finds the value of an expression such as steps.getContacts.response.body
+// or config.crm.instance, whose keys may contain dots
+func (sim *simulation) lookup(expr string) interface{} {
+	var root interface{}
+	switch {
+	case strings.HasPrefix(expr, "config."):
+		key := strings.TrimPrefix(expr, "config.")
+		if v, ok := sim.ctx.Config[key]; ok {
+			return v
+		}
+		return lookupPath(sim.ctx.Config, key)
+	case strings.HasPrefix(expr, "steps."):
+		root = sim.ctx.Steps
+		expr = strings.TrimPrefix(expr, "steps.")
+	case expr == "trigger":
+		return sim.ctx.Trigger
+	case strings.HasPrefix(expr, "trigger."):
+		root = sim.ctx.Trigger
+		expr = strings.TrimPrefix(expr, "trigger.")
+	default:
+		return nil
+	}
+	return lookupPath(root, expr)
+}
+
+// lookupPath follows a dotted path into a decoded JSON value
+func lookupPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[part]
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscanf(part, "%d", &i); err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,59 @@
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimulateFalseFilterStops(t *testing.T) {
+	f, err := Parse([]byte(`{
+  "name": "filtered",
+  "triggers": [{"type": "manual", "onSuccess": ["isContact"]}],
+  "steps": [
+    {"name": "isContact", "type": "filter", "properties": {"body": "done(trigger.type === 'contact');"}, "onSuccess": ["sync"]},
+    {"name": "sync", "type": "script", "properties": {"body": "done({});"}}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ex, err := Simulate(f, map[string]interface{}{"type": "account"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Status != ExecutionSuccess {
+		t.Errorf("status = %s, want %s (errors %v)", ex.Status, ExecutionSuccess, ex.Errors)
+	}
+	if want := []string{"isContact"}; !reflect.DeepEqual(ex.Path, want) {
+		t.Errorf("path = %v, want %v", ex.Path, want)
+	}
+
+	ex, err = Simulate(f, map[string]interface{}{"type": "contact"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"isContact", "sync"}; ex.Status != ExecutionSuccess || !reflect.DeepEqual(ex.Path, want) {
+		t.Errorf("status = %s path = %v, want %s %v", ex.Status, ex.Path, ExecutionSuccess, want)
+	}
+}
+
+func TestSimulateThrownErrorFails(t *testing.T) {
+	f, err := Parse([]byte(`{
+  "name": "throws",
+  "triggers": [{"type": "manual", "onSuccess": ["boom"]}],
+  "steps": [
+    {"name": "boom", "type": "script", "properties": {"body": "throw new Error('bad');"}}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex, err := Simulate(f, map[string]interface{}{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.Status != ExecutionFailed || ex.Errors["boom"] == "" {
+		t.Errorf("status = %s errors = %v, want failed with an error for boom", ex.Status, ex.Errors)
+	}
+}
@@ -0,0 +1,290 @@
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultTemplate is the template used when none is named
+const DefaultTemplate = "basic"
+
+// TriggerRequest is the name formulas new gives an elementRequest trigger
+const TriggerRequest = "request"
+
+// Templates are the built-in Formula templates. A template is Formula JSON in
+// which {{name}} is replaced by the new Formula's name. The trigger of a template,
+// if it has one, gives the default trigger type and the first steps to run.
+var Templates = map[string]string{
+	"basic": `{
+  "name": "{{name}}",
+  "steps": [
+    {
+      "name": "start",
+      "type": "script",
+      "properties": {
+        "body": "// trigger is the event, request or schedule that started this execution\ndone({\n  trigger: trigger\n});"
+      },
+      "onSuccess": [],
+      "onFailure": []
+    }
+  ]
+}`,
+
+	"polling-sync": `{
+  "name": "{{name}}",
+  "description": "Fetches records changed since the last run and syncs each one",
+  "configuration": [
+    {"key": "source", "name": "Source", "type": "elementInstance", "required": true},
+    {"key": "objectName", "name": "Object name", "type": "value", "required": true}
+  ],
+  "triggers": [
+    {"type": "scheduled", "properties": {"cron": "0 0/15 * * * ?"}, "onSuccess": ["buildQuery"], "onFailure": []}
+  ],
+  "steps": [
+    {
+      "name": "buildQuery",
+      "type": "script",
+      "properties": {
+        "body": "// look back over the schedule's interval\nvar since = new Date(Date.now() - 15 * 60 * 1000).toISOString();\ndone({\n  query: { where: \"lastModifiedDate >= '\" + since + \"'\" }\n});"
+      },
+      "onSuccess": ["getChanges"],
+      "onFailure": []
+    },
+    {
+      "name": "getChanges",
+      "type": "elementRequest",
+      "properties": {
+        "elementInstanceId": "${config.source}",
+        "method": "GET",
+        "api": "/${config.objectName}",
+        "query": "${steps.buildQuery.query}"
+      },
+      "onSuccess": ["hasChanges"],
+      "onFailure": []
+    },
+    {
+      "name": "hasChanges",
+      "type": "filter",
+      "properties": {
+        "body": "done(steps.getChanges.response.body.length > 0);"
+      },
+      "onSuccess": ["eachRecord"],
+      "onFailure": []
+    },
+    {
+      "name": "eachRecord",
+      "type": "loop",
+      "properties": {
+        "list": "${steps.getChanges.response.body}"
+      },
+      "onSuccess": ["syncRecord"],
+      "onFailure": []
+    },
+    {
+      "name": "syncRecord",
+      "type": "script",
+      "properties": {
+        "body": "var record = steps.eachRecord.entry;\n// sync the record here\ndone({\n  record: record\n});"
+      },
+      "onSuccess": ["eachRecord"],
+      "onFailure": ["eachRecord"]
+    }
+  ]
+}`,
+
+	"event-fanout": `{
+  "name": "{{name}}",
+  "description": "Sends each object of an event on to another service",
+  "configuration": [
+    {"key": "source", "name": "Source", "type": "elementInstance", "required": true},
+    {"key": "targetUrl", "name": "Target URL", "type": "value", "required": true}
+  ],
+  "triggers": [
+    {"type": "event", "properties": {"elementInstanceId": "${config.source}"}, "onSuccess": ["collectEvents"], "onFailure": []}
+  ],
+  "steps": [
+    {
+      "name": "collectEvents",
+      "type": "script",
+      "properties": {
+        "body": "// polled events arrive together, webhooks one at a time\nvar events = (trigger.body && trigger.body.message && trigger.body.message.events) || [trigger.event];\ndone({\n  events: events\n});"
+      },
+      "onSuccess": ["eachEvent"],
+      "onFailure": []
+    },
+    {
+      "name": "eachEvent",
+      "type": "loop",
+      "properties": {
+        "list": "${steps.collectEvents.events}"
+      },
+      "onSuccess": ["sendEvent"],
+      "onFailure": []
+    },
+    {
+      "name": "sendEvent",
+      "type": "httpRequest",
+      "properties": {
+        "method": "POST",
+        "url": "${config.targetUrl}",
+        "body": "${steps.eachEvent.entry}"
+      },
+      "onSuccess": ["eachEvent"],
+      "onFailure": ["eachEvent"]
+    }
+  ]
+}`,
+
+	"paged-request": `{
+  "name": "{{name}}",
+  "description": "Reads every page of a resource, following the next page token",
+  "configuration": [
+    {"key": "source", "name": "Source", "type": "elementInstance", "required": true},
+    {"key": "objectName", "name": "Object name", "type": "value", "required": true}
+  ],
+  "triggers": [
+    {"type": "manual", "onSuccess": ["pageQuery"], "onFailure": []}
+  ],
+  "steps": [
+    {
+      "name": "pageQuery",
+      "type": "script",
+      "properties": {
+        "body": "var query = { pageSize: 200 };\nvar token = steps.getPage && steps.getPage.response.headers['elements-next-page-token'];\nif (token) {\n  query.nextPage = token;\n}\ndone({\n  query: query\n});"
+      },
+      "onSuccess": ["getPage"],
+      "onFailure": []
+    },
+    {
+      "name": "getPage",
+      "type": "elementRequest",
+      "properties": {
+        "elementInstanceId": "${config.source}",
+        "method": "GET",
+        "api": "/${config.objectName}",
+        "query": "${steps.pageQuery.query}"
+      },
+      "onSuccess": ["processPage"],
+      "onFailure": []
+    },
+    {
+      "name": "processPage",
+      "type": "script",
+      "properties": {
+        "body": "var total = (steps.processPage && steps.processPage.total) || 0;\n// work with steps.getPage.response.body here\ndone({\n  total: total + steps.getPage.response.body.length\n});"
+      },
+      "onSuccess": ["hasNextPage"],
+      "onFailure": []
+    },
+    {
+      "name": "hasNextPage",
+      "type": "filter",
+      "properties": {
+        "body": "done(!!steps.getPage.response.headers['elements-next-page-token']);"
+      },
+      "onSuccess": ["pageQuery"],
+      "onFailure": []
+    }
+  ]
+}`,
+}
+
+// TemplateNames returns the names of the built-in templates
+func TemplateNames() []string {
+	var names []string
+	for name := range Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sourceConfiguration is declared for triggers that listen to an Element Instance
+var sourceConfiguration = Configuration{Key: "source", Name: "Source", Type: "elementInstance", Required: true}
+
+// New creates Formula JSON named name from a template, with a trigger of the
+// given type: manual, event, scheduled or request. An empty trigger type keeps
+// the template's trigger, or is manual if the template has none. Everything else
+// in the template, including fields Formula doesn't model, is kept as it is.
+func New(name, triggerType string, template []byte) ([]byte, error) {
+	data := []byte(strings.Replace(string(template), "{{name}}", jsonEscape(name), -1))
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("template isn't Formula JSON: %s", err.Error())
+	}
+	doc, err := decodeObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("template isn't Formula JSON: %s", err.Error())
+	}
+	if len(f.Steps) == 0 {
+		return nil, fmt.Errorf("template has no steps")
+	}
+
+	first := []string{f.Steps[0].Name}
+	if len(f.Triggers) > 0 {
+		first = f.Triggers[0].OnSuccess
+		if triggerType == "" {
+			triggerType = f.Triggers[0].Type
+		}
+	}
+	if triggerType == "" {
+		triggerType = TriggerManual
+	}
+
+	var trigger Trigger
+	if len(f.Triggers) > 0 && f.Triggers[0].Type == triggerType {
+		trigger = f.Triggers[0]
+		triggers, _ := doc["triggers"].([]interface{})
+		doc["triggers"] = triggers[:1]
+	} else {
+		trigger, err = newTrigger(triggerType)
+		if err != nil {
+			return nil, err
+		}
+		trigger.OnSuccess = first
+		trigger.OnFailure = []string{}
+		doc["triggers"] = []interface{}{trigger}
+	}
+
+	// declare the configuration the trigger uses
+	declared := f.ConfigKeys()
+	for _, key := range configReferences(trigger.Properties) {
+		if _, ok := declared[key]; !ok && key == sourceConfiguration.Key {
+			configuration, _ := doc["configuration"].([]interface{})
+			doc["configuration"] = append(configuration, sourceConfiguration)
+		}
+	}
+
+	doc["name"] = name
+	return encodeJSON(doc)
+}
+
+// newTrigger returns a trigger of the given type with the properties it needs
+func newTrigger(triggerType string) (Trigger, error) {
+	switch triggerType {
+	case TriggerManual:
+		return Trigger{Type: TriggerManual}, nil
+	case TriggerEvent:
+		return Trigger{Type: TriggerEvent, Properties: map[string]interface{}{
+			"elementInstanceId": "${config.source}",
+		}}, nil
+	case TriggerScheduled:
+		return Trigger{Type: TriggerScheduled, Properties: map[string]interface{}{
+			"cron": "0 0/15 * * * ?",
+		}}, nil
+	case TriggerRequest, TriggerElementRequest:
+		return Trigger{Type: TriggerElementRequest, Properties: map[string]interface{}{
+			"elementInstanceId": "${config.source}",
+			"method":            "POST",
+			"api":               "/hubs/crm/contacts",
+		}}, nil
+	}
+	return Trigger{}, fmt.Errorf("unknown trigger %s, must be one of manual, event, scheduled, request", triggerType)
+}
+
+// jsonEscape escapes a string for use inside a JSON string
+func jsonEscape(s string) string {
+	data, _ := encodeJSON(s)
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(string(data)), `"`), `"`)
+}
@@ -0,0 +1,53 @@
+package formula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewKeepsUnmodelledFields(t *testing.T) {
+	template := []byte(`{
+  "name": "{{name}}",
+  "engine": "v3",
+  "futureField": {"a": 1},
+  "triggers": [{"type": "manual", "onSuccess": ["start"], "onFailure": [], "extra": "kept"}],
+  "steps": [{"name": "start", "type": "script", "stepField": true, "properties": {"body": "done({});"}}]
+}`)
+
+	data, err := New("kept", "", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := decodeObject(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["name"] != "kept" || doc["futureField"] == nil {
+		t.Errorf("New() = %s, want the name set and futureField kept", data)
+	}
+	trigger := doc["triggers"].([]interface{})[0].(map[string]interface{})
+	if trigger["extra"] != "kept" {
+		t.Errorf("trigger = %v, want extra kept", trigger)
+	}
+	if step := doc["steps"].([]interface{})[0].(map[string]interface{}); step["stepField"] != true {
+		t.Errorf("step = %v, want stepField kept", step)
+	}
+
+	data, err = New("evented", TriggerEvent, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Triggers) != 1 || f.Triggers[0].Type != TriggerEvent || !reflect.DeepEqual(f.Triggers[0].OnSuccess, []string{"start"}) {
+		t.Errorf("triggers = %+v, want one event trigger starting at start", f.Triggers)
+	}
+	if _, ok := f.ConfigKeys()["source"]; !ok {
+		t.Errorf("configuration = %+v, want source declared", f.Configuration)
+	}
+	if doc, _ := decodeObject(data); doc["futureField"] == nil {
+		t.Errorf("New() = %s, want futureField kept", data)
+	}
+}
@@ -0,0 +1,212 @@
+package formula
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Files of an unpacked Formula
+const (
+	UnpackedFormula       = "formula.json"
+	UnpackedConfiguration = "configuration.json"
+	UnpackedSteps         = "steps"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Unpack writes Formula JSON into dir as formula.json, with the body of each
+// script and filter step in steps/<name>.js and the configuration in
+// configuration.json. Scripts are written exactly as they are, so Pack gives
+// back the same Formula. Scripts in steps/ that no longer belong to a step are removed.
+func Unpack(data []byte, dir string) error {
+	doc, err := decodeObject(data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, UnpackedSteps), 0755); err != nil {
+		return err
+	}
+
+	if config, ok := doc["configuration"]; ok {
+		if err := writeJSON(filepath.Join(dir, UnpackedConfiguration), config); err != nil {
+			return err
+		}
+		delete(doc, "configuration")
+	} else if err := removeIfExists(filepath.Join(dir, UnpackedConfiguration)); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	err = forEachScript(doc, func(props map[string]interface{}, file string) error {
+		body, ok := props["body"].(string)
+		if !ok {
+			return nil
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(body), 0644); err != nil {
+			return err
+		}
+		delete(props, "body")
+		written[file] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, UnpackedSteps, "*.js"))
+	if err != nil {
+		return err
+	}
+	for _, s := range stale {
+		if !written[filepath.Join(UnpackedSteps, filepath.Base(s))] {
+			if err := os.Remove(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeJSON(filepath.Join(dir, UnpackedFormula), doc)
+}
+
+// Pack reassembles the Formula JSON from a directory written by Unpack
+func Pack(dir string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, UnpackedFormula))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", UnpackedFormula, err.Error())
+	}
+
+	config, err := ioutil.ReadFile(filepath.Join(dir, UnpackedConfiguration))
+	switch {
+	case err == nil:
+		var v interface{}
+		if err := decode(config, &v); err != nil {
+			return nil, fmt.Errorf("%s: %s", UnpackedConfiguration, err.Error())
+		}
+		doc["configuration"] = v
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	err = forEachScript(doc, func(props map[string]interface{}, file string) error {
+		body, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := props["body"]; ok {
+			return fmt.Errorf("step has a body in %s and in %s", UnpackedFormula, file)
+		}
+		props["body"] = string(body)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeJSON(doc)
+}
+
+// forEachScript calls fn with the properties of each script and filter step,
+// creating them if need be, and the path within an unpacked directory of its script
+func forEachScript(doc map[string]interface{}, fn func(props map[string]interface{}, file string) error) error {
+	steps, _ := doc["steps"].([]interface{})
+	used := make(map[string]bool)
+	for _, s := range steps {
+		step, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if t, _ := step["type"].(string); t != StepScript && t != StepFilter {
+			continue
+		}
+		name, _ := step["name"].(string)
+		file := scriptFileName(name, used)
+
+		props, ok := step["properties"].(map[string]interface{})
+		if !ok {
+			if step["properties"] != nil {
+				continue
+			}
+			props = make(map[string]interface{})
+		}
+		if err := fn(props, file); err != nil {
+			return fmt.Errorf("step %s: %s", name, err.Error())
+		}
+		if len(props) > 0 || step["properties"] != nil {
+			step["properties"] = props
+		}
+	}
+	return nil
+}
+
+// scriptFileName returns a file name for a step's script that is safe on any
+// filesystem and unique among the names already used, whatever their case
+func scriptFileName(name string, used map[string]bool) string {
+	base := strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), ".")
+	if base == "" {
+		base = "step"
+	}
+	file := base
+	for i := 2; used[strings.ToLower(file)]; i++ {
+		file = fmt.Sprintf("%s-%v", base, i)
+	}
+	used[strings.ToLower(file)] = true
+	return filepath.Join(UnpackedSteps, file+".js")
+}
+
+func decode(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func decodeObject(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := decode(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	return doc, nil
+}
+
+// encodeJSON writes indented JSON without escaping <, > and & in scripts
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(filename string, v interface{}) error {
+	data, err := encodeJSON(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+func removeIfExists(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package formula
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUnpackPackRoundTrip(t *testing.T) {
+	original := []byte(`{
+  "id": 9007199254740993,
+  "name": "round trip",
+  "futureField": {"nested": [1, 2.50, "x"]},
+  "configuration": [{"key": "crm", "type": "elementInstance", "extra": true}],
+  "triggers": [{"type": "manual", "onSuccess": ["a b"]}],
+  "steps": [
+    {"name": "a b", "type": "script", "unknownStepField": "kept", "properties": {"body": "if (a < b && b > c) {\n  done({html: '<p>&amp;</p>'});\n}\n\n"}, "onSuccess": ["a/b"]},
+    {"name": "a/b", "type": "filter", "properties": {"body": "done(true);\n", "mimeType": "text/javascript"}, "onSuccess": ["A_b"]},
+    {"name": "A_b", "type": "script", "properties": {"body": ""}},
+    {"name": "get", "id": 12345678901234567890, "type": "elementRequest", "properties": {"api": "/hubs/crm/contacts?where=a<b&c>d"}}
+  ]
+}`)
+
+	dir, err := ioutil.TempDir("", "unpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := Unpack(original, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, UnpackedSteps, "*.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	if want := []string{"A_b-3.js", "a_b-2.js", "a_b.js"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("script files = %v, want %v", names, want)
+	}
+	script, err := ioutil.ReadFile(filepath.Join(dir, UnpackedSteps, "a_b.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "if (a < b && b > c) {\n  done({html: '<p>&amp;</p>'});\n}\n\n"; string(script) != want {
+		t.Errorf("a_b.js = %q, want %q", script, want)
+	}
+
+	packed, err := Pack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(packed), "where=a<b&c>d") {
+		t.Errorf("Pack escaped <, > or &:\n%s", packed)
+	}
+	want, err := decodeObject(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeObject(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Pack(Unpack(f)) =\n%s\nwant the original", packed)
+	}
+
+	// unpacking again changes nothing
+	if err := Unpack(packed, dir); err != nil {
+		t.Fatal(err)
+	}
+	repacked, err := Pack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(repacked) != string(packed) {
+		t.Errorf("second round trip =\n%s\nwant\n%s", repacked, packed)
+	}
+}